@@ -0,0 +1,225 @@
+package base
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DialStrategy int
+
+const (
+	// Try addresses in the order given.
+	DialFailover DialStrategy = iota
+	// Start each dial at the next address in turn.
+	DialRoundRobin
+)
+
+// Retry and failover behavior for `Dialable.Dial`, taken from the URI query.
+type DialOptions struct {
+	// strategy=failover|roundrobin
+	Strategy DialStrategy
+	// attempts=N; number of passes over the address list. Defaults to 1.
+	Attempts int
+	// timeout=2s; limit for each connection attempt. Zero for none.
+	AttemptTimeout time.Duration
+	// backoff=100ms, max_backoff=5s; delay between passes, doubling each time, with jitter.
+	Backoff, MaxBackoff time.Duration
+	// down=30s; how long an address that failed to connect is skipped while others are healthy.
+	DownTime time.Duration
+}
+
+func parseDuration(q url.Values, key string) (time.Duration, error) {
+	v := q.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("Dialable URI has invalid %s '%s'", key, v)
+	}
+	return d, nil
+}
+
+func (o *DialOptions) parse(q url.Values) (err error) {
+	switch q.Get("strategy") {
+	case "", "failover":
+		o.Strategy = DialFailover
+	case "roundrobin":
+		o.Strategy = DialRoundRobin
+	default:
+		return fmt.Errorf("Dialable URI has unknown strategy '%s'", q.Get("strategy"))
+	}
+
+	if v := q.Get("attempts"); v != "" {
+		if o.Attempts, err = strconv.Atoi(v); err != nil || o.Attempts < 1 {
+			return fmt.Errorf("Dialable URI has invalid attempts '%s'", v)
+		}
+	}
+	if o.AttemptTimeout, err = parseDuration(q, "timeout"); err != nil {
+		return
+	}
+	if o.Backoff, err = parseDuration(q, "backoff"); err != nil {
+		return
+	}
+	if o.MaxBackoff, err = parseDuration(q, "max_backoff"); err != nil {
+		return
+	}
+	if o.DownTime, err = parseDuration(q, "down"); err != nil {
+		return
+	}
+	return nil
+}
+
+// Splits a comma-separated address list out of the authority of a URI, returning the URI with only the first
+// address and the list itself, or nil if there is no list.
+func splitAddressList(s string) (string, []string) {
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return s, nil
+	}
+	start := i + 3
+	end := len(s)
+	if j := strings.IndexAny(s[start:], "/?#"); j >= 0 {
+		end = start + j
+	}
+
+	authority := s[start:end]
+	if !strings.Contains(authority, ",") {
+		return s, nil
+	}
+	addrs := strings.Split(authority, ",")
+	return s[:start] + addrs[0] + s[end:], addrs
+}
+
+// Passive health state of a dialable's addresses:
+type dialHealth struct {
+	lock      sync.Mutex
+	next      int
+	downUntil map[string]time.Time
+}
+
+// Returns the addresses to try for one pass, healthy ones first:
+func (d *Dialable) dialOrder() []string {
+	d.health.lock.Lock()
+	defer d.health.lock.Unlock()
+
+	n := len(d.Addresses)
+	start := 0
+	if d.Strategy == DialRoundRobin && n > 0 {
+		start = d.health.next % n
+		d.health.next = (start + 1) % n
+	}
+
+	now := time.Now()
+	healthy := make([]string, 0, n)
+	var down []string
+	for i := 0; i < n; i++ {
+		addr := d.Addresses[(start+i)%n]
+		if until, ok := d.health.downUntil[addr]; ok && now.Before(until) {
+			down = append(down, addr)
+		} else {
+			healthy = append(healthy, addr)
+		}
+	}
+	return append(healthy, down...)
+}
+
+func (d *Dialable) markHealth(addr string, err error) {
+	if d.DownTime <= 0 {
+		return
+	}
+
+	d.health.lock.Lock()
+	defer d.health.lock.Unlock()
+
+	if err == nil {
+		delete(d.health.downUntil, addr)
+		return
+	}
+	if d.health.downUntil == nil {
+		d.health.downUntil = make(map[string]time.Time)
+	}
+	d.health.downUntil[addr] = time.Now().Add(d.DownTime)
+}
+
+func (d *Dialable) dialAddress(ctx context.Context, addr string) (net.Conn, error) {
+	if d.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.AttemptTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	if d.TLS == nil {
+		return dialer.DialContext(ctx, d.Network, addr)
+	}
+
+	config, err := d.tlsConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	td := tls.Dialer{NetDialer: &dialer, Config: config}
+	return td.DialContext(ctx, d.Network, addr)
+}
+
+// Connects to one of the dialable's addresses, performing a TLS handshake for `tls://` dialables.
+// Addresses are tried per `DialOptions`, backing off between passes, until one connects, the attempts run out or
+// `ctx` is done.
+func (d *Dialable) Dial(ctx context.Context) (net.Conn, error) {
+	addrs := d.Addresses
+	if len(addrs) == 0 {
+		addrs = []string{d.Address}
+	}
+	attempts := d.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := d.Backoff
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			// Wait between half and all of the backoff:
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			}
+
+			backoff *= 2
+			if d.MaxBackoff > 0 && backoff > d.MaxBackoff {
+				backoff = d.MaxBackoff
+			}
+		}
+
+		order := addrs
+		if len(d.Addresses) > 0 {
+			order = d.dialOrder()
+		}
+		for _, addr := range order {
+			c, err := d.dialAddress(ctx, addr)
+			d.markHealth(addr, err)
+			if err == nil {
+				return c, nil
+			}
+			lastErr = err
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("dial %s failed after %d attempt(s): %w", strings.Join(addrs, ","), attempts, lastErr)
+}
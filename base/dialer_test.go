@@ -0,0 +1,168 @@
+package base
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Listens on a local port, accepting and closing connections until the test ends:
+func liveAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// Returns a local address nothing listens on:
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestParseDialableOptions(t *testing.T) {
+	d, err := ParseDialable("tcp://a:1,b:2,c:3/?strategy=roundrobin&attempts=3&timeout=2s&backoff=100ms&max_backoff=1s&down=30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Address != "a:1" || !reflect.DeepEqual(d.Addresses, []string{"a:1", "b:2", "c:3"}) {
+		t.Errorf("addresses %q %q", d.Address, d.Addresses)
+	}
+	want := DialOptions{
+		Strategy:       DialRoundRobin,
+		Attempts:       3,
+		AttemptTimeout: 2 * time.Second,
+		Backoff:        100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		DownTime:       30 * time.Second,
+	}
+	if d.DialOptions != want {
+		t.Errorf("options %+v, want %+v", d.DialOptions, want)
+	}
+
+	for _, uri := range []string{
+		"tcp://a:1?strategy=random",
+		"tcp://a:1?attempts=0",
+		"tcp://a:1?backoff=soon",
+	} {
+		if _, err := ParseDialable(uri); err == nil {
+			t.Errorf("%s: no error", uri)
+		}
+	}
+}
+
+func TestDialFailover(t *testing.T) {
+	dead, live := deadAddr(t), liveAddr(t)
+	d, err := ParseDialable("tcp://" + dead + "," + live + "?down=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RemoteAddr().String(); got != live {
+		t.Errorf("connected to %s, want %s", got, live)
+	}
+	c.Close()
+
+	// The dead address is now tried last:
+	if order := d.dialOrder(); !reflect.DeepEqual(order, []string{live, dead}) {
+		t.Errorf("order %q", order)
+	}
+}
+
+func TestDialRoundRobin(t *testing.T) {
+	d, err := ParseDialable("tcp://a:1,b:2,c:3?strategy=roundrobin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]string{
+		{"a:1", "b:2", "c:3"},
+		{"b:2", "c:3", "a:1"},
+		{"c:3", "a:1", "b:2"},
+		{"a:1", "b:2", "c:3"},
+	} {
+		if order := d.dialOrder(); !reflect.DeepEqual(order, want) {
+			t.Errorf("order %q, want %q", order, want)
+		}
+	}
+}
+
+func TestDialDownMarking(t *testing.T) {
+	d, err := ParseDialable("tcp://a:1,b:2,c:3?down=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.markHealth("a:1", context.DeadlineExceeded)
+	if order := d.dialOrder(); !reflect.DeepEqual(order, []string{"b:2", "c:3", "a:1"}) {
+		t.Errorf("order %q with a:1 down", order)
+	}
+	// A success brings it back:
+	d.markHealth("a:1", nil)
+	if order := d.dialOrder(); !reflect.DeepEqual(order, []string{"a:1", "b:2", "c:3"}) {
+		t.Errorf("order %q with a:1 up", order)
+	}
+	// As does waiting out the down time:
+	d.markHealth("b:2", context.DeadlineExceeded)
+	time.Sleep(60 * time.Millisecond)
+	if order := d.dialOrder(); !reflect.DeepEqual(order, []string{"a:1", "b:2", "c:3"}) {
+		t.Errorf("order %q after the down time", order)
+	}
+
+	// Without a down time nothing is marked:
+	d.DownTime = 0
+	d.markHealth("a:1", context.DeadlineExceeded)
+	if order := d.dialOrder(); order[0] != "a:1" {
+		t.Errorf("order %q without a down time", order)
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	dead := deadAddr(t)
+	d, err := ParseDialable("tcp://" + dead + "?attempts=3&backoff=40ms&max_backoff=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waits at least half of each backoff, 20ms and then 25ms (capped):
+	start := time.Now()
+	_, err = d.Dial(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempt(s)") {
+		t.Fatalf("err %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("gave up after %v", elapsed)
+	}
+
+	// Cancelling stops the backoff:
+	d.Attempts, d.Backoff, d.MaxBackoff = 10, time.Hour, 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = d.Dial(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err %v, want context.DeadlineExceeded", err)
+	}
+}
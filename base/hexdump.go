@@ -1,111 +1,344 @@
 package base
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
+)
+
+// Highlights a range of bytes [Start, End) in a hex dump with an ANSI SGR color code, e.g. "31" for red or "1;33"
+// for bold yellow.
+type HexHighlight struct {
+	Start, End int64
+	Color      string
+}
+
+// Describes the layout of a hex dump.
+type HexDumper struct {
+	// Bytes per line.
+	Width int
+	// Bytes per space-separated group of hex digits.
+	Group int
+	// Bytes per column; an extra space separates columns. Zero for a single column.
+	Column int
+	// Prefix each line with its offset, followed by OffsetSuffix.
+	Offset       bool
+	OffsetSuffix string
+	// Write a final line with the total length, as `hexdump -C` does.
+	FinalOffset bool
+	// Use upper case hex digits.
+	Uppercase bool
+	// Separates the hex digits from the ASCII column.
+	ASCIISep string
+	// Written on both sides of the ASCII column.
+	ASCIIBorder string
+	// Byte ranges to color.
+	Highlights []HexHighlight
+}
+
+var (
+	// The original layout of `HexDumpToWriter`: 16 bytes per line, no offsets.
+	HexDumpClassic = HexDumper{Width: 16, Group: 1, ASCIISep: " "}
+	// Compatible with `hexdump -C` (without squeezing of repeated lines).
+	HexDumpCanonical = HexDumper{Width: 16, Group: 1, Column: 8, Offset: true, OffsetSuffix: "  ", FinalOffset: true, ASCIISep: "  ", ASCIIBorder: "|"}
+	// Compatible with `xxd`.
+	HexDumpXxd = HexDumper{Width: 16, Group: 2, Offset: true, OffsetSuffix: ": ", ASCIISep: "  "}
 )
 
 func HexDumpToWriter(b []byte, w io.Writer) {
-	const digits = "0123456789abcdef"
-	line := make([]byte, 3*16+16)
-
-	lines := len(b) >> 4
-	remainder := len(b) & 15
-
-	t := 0
-	for i := 0; i < lines; i++ {
-		for j := 0; j < 16; j++ {
-			d := b[t]
-			t++
-			line[j*3+0] = digits[(d>>4)&15]
-			line[j*3+1] = digits[d&15]
-			line[j*3+2] = ' '
-
-			if d >= 32 && d <= 127 {
-				line[16*3+j] = d
-			} else {
-				line[16*3+j] = '.'
-			}
+	HexDumpClassic.Dump(w, b)
+}
+
+func HexDumpToLogger(b []byte, o *log.Logger) {
+	HexDumpClassic.DumpLines(b, func(line string) { o.Println(line) })
+}
+
+func (d *HexDumper) width() int {
+	if d.Width <= 0 {
+		return 16
+	}
+	return d.Width
+}
+
+func (d *HexDumper) group() int {
+	if d.Group <= 0 {
+		return 1
+	}
+	return d.Group
+}
+
+// Number of characters in a full line's hex digits:
+func (d *HexDumper) hexWidth() int {
+	n := 0
+	for i := 0; i < d.width(); i++ {
+		n += d.separatorBefore(i) + 2
+	}
+	return n
+}
+
+// Number of spaces written before the `i`th byte of a line:
+func (d *HexDumper) separatorBefore(i int) int {
+	if i == 0 {
+		return 0
+	}
+	n := 0
+	if i%d.group() == 0 {
+		n++
+	}
+	if d.Column > 0 && i%d.Column == 0 {
+		n++
+	}
+	return n
+}
+
+func (d *HexDumper) color(offset int64) string {
+	for _, h := range d.Highlights {
+		if offset >= h.Start && offset < h.End {
+			return h.Color
 		}
+	}
+	return ""
+}
+
+func isPrintable(c byte) bool {
+	return c >= 32 && c < 127
+}
 
-		fmt.Fprintln(w, string(line))
+// Formats a single line of up to `Width` bytes starting at `offset`:
+func (d *HexDumper) formatLine(line []byte, offset int64) string {
+	digits := "0123456789abcdef"
+	if d.Uppercase {
+		digits = "0123456789ABCDEF"
 	}
 
-	if remainder > 0 {
-		for j := 0; j < remainder; j++ {
-			d := b[t]
-			t++
-			line[j*3+0] = digits[(d>>4)&15]
-			line[j*3+1] = digits[d&15]
-			line[j*3+2] = ' '
+	var s strings.Builder
+	if d.Offset {
+		s.WriteString(d.formatOffset(offset))
+		s.WriteString(d.OffsetSuffix)
+	}
 
-			if d >= 32 && d <= 127 {
-				line[16*3+j] = d
-			} else {
-				line[16*3+j] = '.'
-			}
+	// Hex digits, padded to a full line:
+	n := 0
+	for i, c := range line {
+		sep := d.separatorBefore(i)
+		s.WriteString(strings.Repeat(" ", sep))
+
+		color := d.color(offset + int64(i))
+		if color != "" {
+			s.WriteString("\x1b[" + color + "m")
+		}
+		s.WriteByte(digits[c>>4])
+		s.WriteByte(digits[c&15])
+		if color != "" {
+			s.WriteString("\x1b[0m")
 		}
+		n += sep + 2
+	}
+	s.WriteString(strings.Repeat(" ", d.hexWidth()-n))
 
-		for j := remainder; j < 16; j++ {
-			line[j*3+0] = ' '
-			line[j*3+1] = ' '
-			line[j*3+2] = ' '
-			line[16*3+j] = ' '
+	// ASCII column:
+	s.WriteString(d.ASCIISep)
+	s.WriteString(d.ASCIIBorder)
+	for i, c := range line {
+		if !isPrintable(c) {
+			c = '.'
+		}
+		if color := d.color(offset + int64(i)); color != "" {
+			s.WriteString("\x1b[" + color + "m" + string(c) + "\x1b[0m")
+		} else {
+			s.WriteByte(c)
 		}
+	}
+	s.WriteString(d.ASCIIBorder)
+
+	return s.String()
+}
 
-		fmt.Fprintln(w, string(line))
+func (d *HexDumper) formatOffset(offset int64) string {
+	if d.Uppercase {
+		return fmt.Sprintf("%08X", offset)
 	}
+	return fmt.Sprintf("%08x", offset)
 }
 
-func HexDumpToLogger(b []byte, o *log.Logger) {
-	const digits = "0123456789abcdef"
-	line := make([]byte, 3*16+16)
-
-	lines := len(b) >> 4
-	remainder := len(b) & 15
-
-	t := 0
-	for i := 0; i < lines; i++ {
-		for j := 0; j < 16; j++ {
-			d := b[t]
-			t++
-			line[j*3+0] = digits[(d>>4)&15]
-			line[j*3+1] = digits[d&15]
-			line[j*3+2] = ' '
-
-			if d >= 32 && d <= 127 {
-				line[16*3+j] = d
-			} else {
-				line[16*3+j] = '.'
-			}
+// Calls `f` with each line of the dump of `b`, without line terminators.
+func (d HexDumper) DumpLines(b []byte, f func(line string)) {
+	d.dumpLinesAt(b, 0, f)
+	if d.FinalOffset {
+		f(d.formatOffset(int64(len(b))))
+	}
+}
+
+// Formats `b` as if it started at `offset` in a larger stream:
+func (d *HexDumper) dumpLinesAt(b []byte, offset int64, f func(line string)) {
+	w := d.width()
+	for t := 0; t < len(b); t += w {
+		end := t + w
+		if end > len(b) {
+			end = len(b)
 		}
+		f(d.formatLine(b[t:end], offset+int64(t)))
+	}
+}
+
+// Writes the dump of `b` to `w`.
+func (d HexDumper) Dump(w io.Writer, b []byte) (err error) {
+	d.DumpLines(b, func(line string) {
+		if err == nil {
+			_, err = io.WriteString(w, line+"\n")
+		}
+	})
+	return
+}
+
+// Returns the dump of `b` as a string.
+func (d HexDumper) String(b []byte) string {
+	var s strings.Builder
+	d.Dump(&s, b)
+	return s.String()
+}
 
-		o.Println(string(line))
+// Removes ANSI escape sequences, e.g. from highlighted dumps:
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b[") {
+		return s
 	}
 
-	if remainder > 0 {
-		for j := 0; j < remainder; j++ {
-			d := b[t]
-			t++
-			line[j*3+0] = digits[(d>>4)&15]
-			line[j*3+1] = digits[d&15]
-			line[j*3+2] = ' '
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			i += 2
+			for i < len(s) && !(s[i] >= 0x40 && s[i] <= 0x7e) {
+				i++
+			}
+			continue
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// Parses a dump in this layout back into bytes. Lines of `*` (repeats of the previous line, as `hexdump` squeezes
+// them) are expanded when offsets are present.
+func (d HexDumper) Parse(r io.Reader) ([]byte, error) {
+	var out, prev []byte
+	squeezed := false
 
-			if d >= 32 && d <= 127 {
-				line[16*3+j] = d
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(stripANSI(scanner.Text()), "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.TrimSpace(line) == "*" {
+			if !d.Offset || prev == nil {
+				return nil, fmt.Errorf("line %d: cannot expand '*' without offsets", lineNo)
+			}
+			squeezed = true
+			continue
+		}
+
+		if d.Offset {
+			// Split off the offset:
+			field := line
+			if i := strings.IndexAny(line, ": "); i >= 0 {
+				field = line[:i]
+			}
+			offset, err := strconv.ParseInt(field, 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid offset '%s'", lineNo, field)
+			}
+			line = strings.TrimPrefix(line[len(field):], ":")
+			if strings.HasPrefix(line, d.OffsetSuffix) {
+				line = line[len(d.OffsetSuffix):]
 			} else {
-				line[16*3+j] = '.'
+				line = strings.TrimLeft(line, " ")
+			}
+
+			// Expand repeats of the previous line up to this offset:
+			if squeezed {
+				for int64(len(out)) < offset {
+					out = append(out, prev...)
+				}
+				out = out[:offset]
+				squeezed = false
+			}
+			if offset != int64(len(out)) {
+				return nil, fmt.Errorf("line %d: offset %x does not follow %x", lineNo, offset, len(out))
 			}
 		}
 
-		for j := remainder; j < 16; j++ {
-			line[j*3+0] = ' '
-			line[j*3+1] = ' '
-			line[j*3+2] = ' '
-			line[16*3+j] = ' '
+		// Decode the hex digits:
+		if len(line) > d.hexWidth() {
+			line = line[:d.hexWidth()]
 		}
+		digits := strings.Replace(line, " ", "", -1)
+		if digits == "" {
+			// e.g. the final offset line of `hexdump -C`:
+			continue
+		}
+		if len(digits)%2 != 0 {
+			return nil, fmt.Errorf("line %d: odd number of hex digits", lineNo)
+		}
+
+		prev = make([]byte, len(digits)/2)
+		for i := range prev {
+			c, err := strconv.ParseUint(digits[i*2:i*2+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid hex digits '%s'", lineNo, digits[i*2:i*2+2])
+			}
+			prev[i] = byte(c)
+		}
+		out = append(out, prev...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if squeezed {
+		return nil, errors.New("dump ends with '*' and no final offset")
+	}
 
-		o.Println(string(line))
+	return out, nil
+}
+
+// Parses a dump produced by `HexDumpClassic`, `HexDumpCanonical` (`hexdump -C`) or `HexDumpXxd` (`xxd`), detecting
+// the layout from the first line: an 8 hex digit offset followed by two spaces (or alone) is canonical, followed by
+// ": " is xxd, and anything else is classic.
+func ParseHexDump(s string) ([]byte, error) {
+	first := strings.TrimLeft(stripANSI(s), "\r\n")
+	if i := strings.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+
+	d := HexDumpClassic
+	first = strings.TrimRight(first, "\r")
+	if len(first) == 8 && isHexDigits(first) {
+		// Only the final offset of an empty `hexdump -C` dump:
+		d = HexDumpCanonical
+	} else if len(first) >= 10 && isHexDigits(first[:8]) {
+		switch first[8:10] {
+		case "  ":
+			d = HexDumpCanonical
+		case ": ":
+			d = HexDumpXxd
+		}
+	}
+
+	return d.Parse(strings.NewReader(s))
+}
+
+func isHexDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
 	}
+	return true
 }
@@ -0,0 +1,65 @@
+package base
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHexDumpRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("a|b"),
+		[]byte("hello, world: 0123456789abcdef|"),
+		bytes.Repeat([]byte{0}, 40),
+		{0xde, 0xad, 0xbe, 0xef, '\n', '|', ':', ' '},
+		nil,
+	}
+	layouts := map[string]HexDumper{
+		"classic":   HexDumpClassic,
+		"canonical": HexDumpCanonical,
+		"xxd":       HexDumpXxd,
+	}
+
+	for name, d := range layouts {
+		for _, in := range inputs {
+			dump := d.String(in)
+			out, err := ParseHexDump(dump)
+			if err != nil {
+				t.Errorf("%s: ParseHexDump(%q): %s\n%s", name, in, err, dump)
+				continue
+			}
+			if !bytes.Equal(out, in) {
+				t.Errorf("%s: round trip of %q gave %q\n%s", name, in, out, dump)
+			}
+
+			// The layout's own parser must agree:
+			out, err = d.Parse(bytes.NewReader([]byte(dump)))
+			if err != nil || !bytes.Equal(out, in) {
+				t.Errorf("%s: Parse of %q gave %q, %v", name, in, out, err)
+			}
+		}
+	}
+}
+
+func TestParseHexDumpSqueezed(t *testing.T) {
+	// As `hexdump -C` prints 48 zero bytes:
+	dump := "00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|\n" +
+		"*\n" +
+		"00000030\n"
+	out, err := ParseHexDump(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, make([]byte, 48)) {
+		t.Fatalf("got %d bytes: %x", len(out), out)
+	}
+}
+
+func TestParseHexDumpHighlighted(t *testing.T) {
+	d := HexDumpXxd
+	d.Highlights = []HexHighlight{{Start: 2, End: 5, Color: "31"}}
+	in := []byte("highlighted bytes")
+	out, err := ParseHexDump(d.String(in))
+	if err != nil || !bytes.Equal(out, in) {
+		t.Fatalf("got %q, %v", out, err)
+	}
+}
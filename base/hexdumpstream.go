@@ -0,0 +1,176 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// An io.Writer which hex dumps everything written to it, one complete line at a time, keeping running offsets
+// across writes. Call `Flush` to dump a trailing partial line.
+type HexDumpWriter struct {
+	d   HexDumper
+	out io.Writer
+
+	lock    sync.Mutex
+	offset  int64
+	pending []byte
+	err     error
+}
+
+func NewHexDumpWriter(d HexDumper, out io.Writer) *HexDumpWriter {
+	return &HexDumpWriter{d: d, out: out}
+}
+
+func (w *HexDumpWriter) writeLine(line string) {
+	if w.err == nil {
+		_, w.err = io.WriteString(w.out, line+"\n")
+	}
+}
+
+func (w *HexDumpWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.pending = append(w.pending, p...)
+
+	// Dump all complete lines:
+	full := len(w.pending) - len(w.pending)%w.d.width()
+	w.d.dumpLinesAt(w.pending[:full], w.offset, w.writeLine)
+	w.offset += int64(full)
+	w.pending = append(w.pending[:0], w.pending[full:]...)
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// Dumps any trailing partial line.
+func (w *HexDumpWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.d.dumpLinesAt(w.pending, w.offset, w.writeLine)
+	w.offset += int64(len(w.pending))
+	w.pending = w.pending[:0]
+	return w.err
+}
+
+// Flushes and writes the final offset line, if the layout has one.
+func (w *HexDumpWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.d.FinalOffset {
+		w.writeLine(w.d.formatOffset(w.offset))
+	}
+	return w.err
+}
+
+// Total number of bytes written so far.
+func (w *HexDumpWriter) Offset() int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.offset + int64(len(w.pending))
+}
+
+type hexDumpReader struct {
+	r    io.Reader
+	dump *HexDumpWriter
+}
+
+func (r hexDumpReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if n > 0 {
+		r.dump.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.dump.Flush()
+	}
+	return
+}
+
+// Returns a reader which dumps everything read from `r` to `dump`.
+func NewHexDumpReader(r io.Reader, dump *HexDumpWriter) io.Reader {
+	return hexDumpReader{r: r, dump: dump}
+}
+
+type hexDumpTee struct {
+	w    io.Writer
+	dump *HexDumpWriter
+}
+
+func (t hexDumpTee) Write(p []byte) (n int, err error) {
+	n, err = t.w.Write(p)
+	if n > 0 {
+		t.dump.Write(p[:n])
+	}
+	return
+}
+
+// Returns a writer which writes to `w` and dumps everything successfully written to `dump`.
+func NewHexDumpTee(w io.Writer, dump *HexDumpWriter) io.Writer {
+	return hexDumpTee{w: w, dump: dump}
+}
+
+// Wraps a net.Conn and dumps both directions to a shared output. Each chunk read or written is preceded by a header
+// with a timestamp, a direction marker ("<" for received, ">" for sent) and the remote address; offsets run
+// separately per direction.
+type HexDumpConn struct {
+	net.Conn
+	// Layout of the timestamp in each chunk header, as for `time.Format`.
+	TimestampFormat string
+
+	d   HexDumper
+	out io.Writer
+
+	lock           sync.Mutex
+	received, sent int64
+}
+
+func NewHexDumpConn(c net.Conn, d HexDumper, out io.Writer) *HexDumpConn {
+	return &HexDumpConn{Conn: c, d: d, out: out, TimestampFormat: "15:04:05.000000"}
+}
+
+func (c *HexDumpConn) dump(direction string, p []byte, offset *int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(c.out, "%s %s %s (%d bytes)\n", time.Now().Format(c.TimestampFormat), direction, c.RemoteAddr(), len(p))
+	c.d.dumpLinesAt(p, *offset, func(line string) {
+		io.WriteString(c.out, line+"\n")
+	})
+	*offset += int64(len(p))
+}
+
+func (c *HexDumpConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.dump("<", p[:n], &c.received)
+	}
+	return
+}
+
+func (c *HexDumpConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if n > 0 {
+		c.dump(">", p[:n], &c.sent)
+	}
+	return
+}
+
+// Dials like `Dial` and dumps all traffic on the connection to `out` in the given layout.
+func (d *Dialable) DialHexDump(ctx context.Context, dumper HexDumper, out io.Writer) (net.Conn, error) {
+	c, err := d.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewHexDumpConn(c, dumper, out), nil
+}
@@ -0,0 +1,249 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// First file descriptor passed by the LISTEN_FDS protocol (after stdin, stdout, stderr):
+const listenFdsStart = 3
+
+type inheritedListener struct {
+	net.Listener
+	name string
+	used bool
+}
+
+var (
+	inheritedOnce sync.Once
+	inheritLock   sync.Mutex
+	inherited     []*inheritedListener
+
+	// Unix socket paths handed to a child process which must not be removed on exit:
+	handedOff = make(map[string]bool)
+)
+
+// Adopts listeners passed to this process via the LISTEN_FDS protocol, either by systemd socket activation or by
+// `Reexec` from a parent process.
+func loadInherited() {
+	// LISTEN_PID is optional so that `Reexec` can pass listeners without knowing the child's pid up front:
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Don't pass these on to our own children:
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	inherited = make([]*inheritedListener, n)
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		fd := listenFdsStart + i
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		inherited[i] = &inheritedListener{Listener: l, name: name}
+	}
+}
+
+func claim(il *inheritedListener) net.Listener {
+	if il == nil || il.used {
+		return nil
+	}
+	il.used = true
+	return il.Listener
+}
+
+// Finds the inherited listener for a `systemd://` URI. The host may be blank for the first listener, an index into
+// LISTEN_FDS, or a name from LISTEN_FDNAMES.
+func listenSystemd(la *Listenable) (net.Listener, error) {
+	inheritedOnce.Do(loadInherited)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+
+	if len(inherited) == 0 {
+		return nil, errors.New("no listeners were passed via LISTEN_FDS")
+	}
+
+	var il *inheritedListener
+	if la.Address == "" {
+		il = inherited[0]
+	} else if i, err := strconv.Atoi(la.Address); err == nil {
+		if i < 0 || i >= len(inherited) {
+			return nil, fmt.Errorf("LISTEN_FDS index %d out of range; %d listeners were passed", i, len(inherited))
+		}
+		il = inherited[i]
+	} else {
+		for _, c := range inherited {
+			if c != nil && c.name == la.Address {
+				il = c
+				break
+			}
+		}
+	}
+
+	l := claim(il)
+	if l == nil {
+		return nil, fmt.Errorf("no unused listener passed via LISTEN_FDS for '%s'", la.Address)
+	}
+	return l, nil
+}
+
+// Adopts a listener from an arbitrary inherited file descriptor for an `fd://N` URI.
+func listenFd(la *Listenable) (net.Listener, error) {
+	fd, err := strconv.Atoi(la.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "fd://"+la.Address)
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// Looks for an inherited listener already bound to the address `la` wants, so that a re-executed child takes over
+// its parent's socket instead of trying to bind a new one.
+func adoptInherited(la *Listenable) net.Listener {
+	inheritedOnce.Do(loadInherited)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+
+	for _, il := range inherited {
+		if il == nil || il.used || il.Addr().Network() != la.Network {
+			continue
+		}
+		if sameAddress(la.Network, il.Addr().String(), la.Address) {
+			return claim(il)
+		}
+	}
+	return nil
+}
+
+func sameAddress(network, bound, wanted string) bool {
+	if network == "unix" || network == "unixpacket" {
+		return bound == wanted
+	}
+
+	bhost, bport, err := net.SplitHostPort(bound)
+	if err != nil {
+		return false
+	}
+	whost, wport, err := net.SplitHostPort(wanted)
+	if err != nil {
+		return false
+	}
+	if bport != wport {
+		return false
+	}
+
+	// Treat a blank host as the unspecified address:
+	bip, wip := net.ParseIP(bhost), net.ParseIP(whost)
+	if whost == "" || (wip != nil && wip.IsUnspecified()) {
+		return bip == nil || bip.IsUnspecified()
+	}
+	return bip != nil && bip.Equal(wip)
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Starts a new copy of the running executable with the same arguments and environment, passing it `listeners` via
+// the LISTEN_FDS protocol. The child adopts them through `systemd://` URIs or by binding the same address in
+// `ServeMain`. Unix sockets handed off this way are no longer removed when the parent stops listening.
+func Reexec(listeners ...net.Listener) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, l := range listeners {
+		fl, ok := l.(filer)
+		if !ok {
+			return nil, fmt.Errorf("listener on %s cannot be passed to a child process", l.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "LISTEN_PID=") || strings.HasPrefix(kv, "LISTEN_FDS=") || strings.HasPrefix(kv, "LISTEN_FDNAMES=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env, "LISTEN_FDS="+strconv.Itoa(len(files)))
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// The child owns the unix sockets now:
+	inheritLock.Lock()
+	for _, l := range listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+			handedOff[ul.Addr().String()] = true
+		}
+	}
+	inheritLock.Unlock()
+
+	return cmd.Process, nil
+}
+
+// Hands `listeners` to a new copy of the running executable via `Reexec` and then asks the current process to
+// terminate, so that `ServeMain` (or `ServeMainGraceful`) drains and exits while the child takes over.
+func Upgrade(listeners ...net.Listener) (*os.Process, error) {
+	child, err := Reexec(listeners...)
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return child, err
+	}
+	return child, self.Signal(syscall.SIGTERM)
+}
+
+func handedOffTo(address string) bool {
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+	return handedOff[address]
+}
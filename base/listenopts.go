@@ -0,0 +1,101 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Socket options for listenables, taken from the URI query, e.g. tcp://:8080?reuseport=1&keepalive=30s&backlog=1024
+type ListenOptions struct {
+	// reuseport=1; lets several processes listen on the same port (SO_REUSEPORT).
+	ReusePort bool
+	// keepalive=30s; TCP keep-alive period for accepted connections. Zero for the default, negative (keepalive=off)
+	// to disable.
+	KeepAlive time.Duration
+	// backlog=1024; length of the queue of pending connections. Zero for the system default.
+	Backlog int
+}
+
+func parseListenOptions(q url.Values) (o ListenOptions, err error) {
+	switch v := q.Get("reuseport"); v {
+	case "", "0", "false":
+	case "1", "true":
+		o.ReusePort = true
+	default:
+		return o, fmt.Errorf("Listenable URI has invalid reuseport '%s'", v)
+	}
+
+	switch v := q.Get("keepalive"); v {
+	case "":
+	case "off", "0":
+		o.KeepAlive = -1
+	default:
+		if o.KeepAlive, err = time.ParseDuration(v); err != nil || o.KeepAlive <= 0 {
+			return o, fmt.Errorf("Listenable URI has invalid keepalive '%s'", v)
+		}
+	}
+
+	if v := q.Get("backlog"); v != "" {
+		if o.Backlog, err = strconv.Atoi(v); err != nil || o.Backlog <= 0 {
+			return o, fmt.Errorf("Listenable URI has invalid backlog '%s'", v)
+		}
+	}
+	return o, nil
+}
+
+// Abstract unix socket names (Linux only) start with '@' and have no file on disk:
+func isAbstractSocket(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+// Binds a new socket for `la` with its socket options applied:
+func bind(la *Listenable) (net.Listener, error) {
+	o := la.Options
+	lc := net.ListenConfig{KeepAlive: o.KeepAlive}
+	if o.ReusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) { serr = setReusePort(fd) }); err != nil {
+				return err
+			}
+			return serr
+		}
+	}
+
+	l, err := lc.Listen(context.Background(), la.Network, la.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Backlog > 0 {
+		if err = setBacklog(l, o.Backlog); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Listening again on a listening socket only changes its backlog:
+func setBacklog(l net.Listener, backlog int) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("cannot set backlog on %s listener", l.Addr().Network())
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var lerr error
+	if err = rc.Control(func(fd uintptr) { lerr = relisten(fd, backlog) }); err != nil {
+		return err
+	}
+	return lerr
+}
@@ -0,0 +1,17 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package base
+
+import "syscall"
+
+// Not defined by package syscall on most Linux architectures:
+const soReusePort = 0xf
+
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
+
+func relisten(fd uintptr, backlog int) error {
+	return syscall.Listen(int(fd), backlog)
+}
@@ -0,0 +1,14 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package base
+
+import "errors"
+
+func setReusePort(fd uintptr) error {
+	return errors.New("reuseport is not supported on this platform")
+}
+
+func relisten(fd uintptr, backlog int) error {
+	return errors.New("backlog is not supported on this platform")
+}
@@ -0,0 +1,142 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A server to run on its own listener as part of `ServeMainMulti`.
+type Service struct {
+	Listenable *Listenable
+	Serve      func(net.Listener) error
+	// Optional; called to drain the server on shutdown before its listener is closed.
+	Shutdown ShutdownFunc
+}
+
+// Outcome of a single service run by `ServeMainMulti`:
+type ServiceResult struct {
+	Listenable *Listenable
+	Err        error
+}
+
+// Aggregated outcome of all services run by `ServeMainMulti`; returned only if at least one service failed.
+type ServiceErrors []ServiceResult
+
+func (e ServiceErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, r := range e {
+		outcome := "ok"
+		if r.Err != nil {
+			outcome = r.Err.Error()
+		}
+		lines = append(lines, fmt.Sprintf("%s://%s: %s", r.Listenable.Network, r.Listenable.Address, outcome))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// Main method to start up several servers together, each on its own listener.
+// All services are shut down on the first termination signal or as soon as any one service returns, with up to
+// `drainTimeout` given to each service's `Shutdown` func.
+func ServeMainMulti(services []Service, drainTimeout time.Duration) (sig os.Signal, err error) {
+	// Create all sockets up front so we fail before serving anything:
+	ls := make([]net.Listener, len(services))
+	for i, s := range services {
+		ls[i], err = listen(s.Listenable)
+		if err != nil {
+			for j := 0; j < i; j++ {
+				ls[j].Close()
+				unlisten(services[j].Listenable)
+			}
+			return nil, fmt.Errorf("%s://%s: %s", s.Listenable.Network, s.Listenable.Address, err)
+		}
+	}
+
+	results := make(ServiceErrors, len(services))
+	first := make(chan struct{})
+	var firstOnce sync.Once
+	var wg sync.WaitGroup
+
+	for i, s := range services {
+		results[i].Listenable = s.Listenable
+
+		wg.Add(1)
+		go func(i int, s Service) {
+			defer wg.Done()
+
+			err := s.Serve(ls[i])
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			results[i].Err = err
+
+			firstOnce.Do(func() { close(first) })
+		}(i, s)
+	}
+
+	// Wait for a termination signal or for the first service to stop:
+	sig, _ = Daemonize(func() error {
+		<-first
+		return nil
+	})
+
+	// Drain all services concurrently:
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	shutdownErrs := make([]error, len(services))
+	var swg sync.WaitGroup
+	for i, s := range services {
+		if s.Shutdown == nil {
+			continue
+		}
+
+		swg.Add(1)
+		go func(i int, s Service) {
+			defer swg.Done()
+			shutdownErrs[i] = s.Shutdown(ctx)
+		}(i, s)
+	}
+	swg.Wait()
+	cancel()
+
+	// Stop listening and wait for every service to return:
+	for i, s := range services {
+		ls[i].Close()
+		unlisten(s.Listenable)
+	}
+	wg.Wait()
+
+	// Report shutdown failures for services which otherwise stopped cleanly:
+	for i := range results {
+		if results[i].Err == nil && shutdownErrs[i] != nil {
+			results[i].Err = shutdownErrs[i]
+		}
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return sig, results
+		}
+	}
+	return sig, nil
+}
+
+// Creates a `Service` which runs `srv` on `la` and drains it with `srv.Shutdown`, force-closing it when the drain
+// timeout expires.
+func HTTPService(la *Listenable, srv *http.Server) Service {
+	return Service{
+		Listenable: la,
+		Serve:      srv.Serve,
+		Shutdown: func(ctx context.Context) error {
+			err := srv.Shutdown(ctx)
+			if err == context.DeadlineExceeded {
+				return srv.Close()
+			}
+			return err
+		},
+	}
+}
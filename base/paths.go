@@ -1,23 +1,98 @@
 package base
 
-import "path/filepath"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Maximum number of symlinks followed by `SecureJoin`:
+const maxSymlinkHops = 255
 
 func CanonicalPath(path string) string {
-	abs, err := filepath.Abs(path)
+	abs, err := CanonicalPathErr(path)
 	if err != nil {
 		panic(err)
 	}
-	abs, err = filepath.EvalSymlinks(abs)
+	return abs
+}
+
+// Like `CanonicalPath` but returns errors, e.g. if the path does not exist, instead of panicking.
+func CanonicalPathErr(path string) (string, error) {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return abs
+	return filepath.EvalSymlinks(abs)
 }
 
 func CanonicalSymlinkPath(path string) string {
-	abs, err := filepath.Abs(path)
+	abs, err := CanonicalSymlinkPathErr(path)
 	if err != nil {
 		panic(err)
 	}
 	return abs
 }
+
+// Like `CanonicalSymlinkPath` but returns errors instead of panicking.
+func CanonicalSymlinkPathErr(path string) (string, error) {
+	return filepath.Abs(path)
+}
+
+// Joins an untrusted path onto `root`, guaranteeing the result stays inside `root`.
+// Symlinks are resolved one component at a time as if `root` were the filesystem root, so neither `..` nor a
+// symlink can escape it. Components that do not exist are joined lexically.
+func SecureJoin(root, untrusted string) (string, error) {
+	root = filepath.Clean(root)
+	sep := string(filepath.Separator)
+
+	// The path resolved so far, relative to `root` and always starting with a separator:
+	current := sep
+	remaining := filepath.FromSlash(untrusted)
+	hops := 0
+
+	for remaining != "" {
+		var part string
+		if i := strings.IndexRune(remaining, filepath.Separator); i >= 0 {
+			part, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			part, remaining = remaining, ""
+		}
+
+		// Lexically clean the next path against `current`; `..` cannot climb above the separator:
+		next := filepath.Clean(sep + filepath.Join(current, part))
+		if next == sep {
+			current = sep
+			continue
+		}
+
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			current = next
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		// Follow the symlink, relative to `root` if absolute or else to its own folder:
+		hops++
+		if hops > maxSymlinkHops {
+			return "", errors.New("SecureJoin: too many levels of symbolic links")
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			current = sep
+		}
+		remaining = dest + sep + remaining
+	}
+
+	return filepath.Join(root, current), nil
+}
@@ -0,0 +1,103 @@
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates a root folder with:
+//
+//	root/a/b/file
+//	root/a/up       -> ../..         (relative, climbs to root)
+//	root/a/abs      -> /a/b          (absolute, taken relative to root)
+//	root/escape     -> ../../..      (relative, tries to leave root)
+//	root/host       -> /etc          (absolute, tries to leave root)
+//	root/loop1      -> loop2
+//	root/loop2      -> loop1
+//	root/dangling   -> missing/child
+func securejoinRoot(t *testing.T) string {
+	root := t.TempDir()
+	mk := func(path string) {
+		if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(dest, path string) {
+		if err := os.Symlink(dest, filepath.Join(root, path)); err != nil {
+			t.Skipf("symlinks unsupported: %s", err)
+		}
+	}
+
+	mk("a/b")
+	if err := os.WriteFile(filepath.Join(root, "a/b/file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link("../..", "a/up")
+	link("/a/b", "a/abs")
+	link("../../..", "escape")
+	link("/etc", "host")
+	link("loop2", "loop1")
+	link("loop1", "loop2")
+	link("missing/child", "dangling")
+	return root
+}
+
+func TestSecureJoin(t *testing.T) {
+	root := securejoinRoot(t)
+
+	tests := []struct {
+		untrusted string
+		want      string
+	}{
+		{"", "/"},
+		{"a/b/file", "/a/b/file"},
+		{"/a/b/file", "/a/b/file"},
+		// `..` climbs stop at the root:
+		{"..", "/"},
+		{"../../../etc/passwd", "/etc/passwd"},
+		{"a/../../../a/b", "/a/b"},
+		{"a/b/../../..", "/"},
+		// Relative symlinks resolve from their own folder and can't leave the root:
+		{"a/up", "/"},
+		{"a/up/a/b/file", "/a/b/file"},
+		{"escape", "/"},
+		{"escape/etc/passwd", "/etc/passwd"},
+		// Absolute symlinks are taken relative to the root:
+		{"a/abs/file", "/a/b/file"},
+		{"host/passwd", "/etc/passwd"},
+		// `..` after a symlink applies to where it points:
+		{"a/abs/../b/file", "/a/b/file"},
+		// Non-existent tails are joined lexically:
+		{"a/b/new/deeper", "/a/b/new/deeper"},
+		{"a/missing/../b", "/a/b"},
+		{"dangling", "/missing/child"},
+		{"dangling/../x", "/missing/x"},
+	}
+
+	for _, tt := range tests {
+		got, err := SecureJoin(root, tt.untrusted)
+		if err != nil {
+			t.Errorf("SecureJoin(%q): %s", tt.untrusted, err)
+			continue
+		}
+		want := filepath.Join(root, filepath.FromSlash(tt.want))
+		if got != want {
+			t.Errorf("SecureJoin(%q) = %q, want %q", tt.untrusted, got, want)
+		}
+		if got != filepath.Clean(root) && !strings.HasPrefix(got, filepath.Clean(root)+string(filepath.Separator)) {
+			t.Errorf("SecureJoin(%q) = %q escapes the root", tt.untrusted, got)
+		}
+	}
+}
+
+func TestSecureJoinLoop(t *testing.T) {
+	root := securejoinRoot(t)
+
+	for _, untrusted := range []string{"loop1", "loop2/x", "a/../loop1/b"} {
+		if got, err := SecureJoin(root, untrusted); err == nil {
+			t.Errorf("SecureJoin(%q) = %q, want a symlink loop error", untrusted, got)
+		}
+	}
+}
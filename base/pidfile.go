@@ -0,0 +1,139 @@
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returned by `CreatePidFile` when another live process holds the PID file.
+type AlreadyRunningError struct {
+	Path string
+	Pid  int
+}
+
+func (e *AlreadyRunningError) Error() string {
+	return fmt.Sprintf("already running as pid %d according to '%s'", e.Pid, e.Path)
+}
+
+// A PID file holding an advisory lock for as long as the process runs, guarding against a second instance.
+type PidFile struct {
+	Path string
+	f    *os.File
+}
+
+// How many times `CreatePidFile` retries when the file it locked was replaced under it:
+const pidFileAttempts = 10
+
+// Creates and locks the PID file at `path` and writes the current pid to it.
+// A PID file left behind by a process which is no longer running (and so no longer holds the lock) is treated as
+// stale and taken over.
+func CreatePidFile(path string) (*PidFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0775)|os.ModeDir); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < pidFileAttempts; attempt++ {
+		f, err := openPidFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = lockFile(f); err != nil {
+			f.Close()
+			if err == errLocked {
+				return nil, &AlreadyRunningError{Path: path, Pid: readPid(path)}
+			}
+			return nil, err
+		}
+
+		// The owner may have removed the file between our open and lock, leaving us holding the lock on a deleted
+		// file while someone else creates a new one; only a lock on the file still at `path` counts:
+		if same, err := isFileAt(f, path); err != nil {
+			f.Close()
+			return nil, err
+		} else if !same {
+			f.Close()
+			continue
+		}
+
+		// The previous contents, if any, are from a stale run:
+		if err = f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err = f.Sync(); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return &PidFile{Path: path, f: f}, nil
+	}
+
+	return nil, fmt.Errorf("PID file '%s' keeps being replaced while locking it", path)
+}
+
+// Whether the open file `f` is the one currently at `path`:
+func isFileAt(f *os.File, path string) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, pi), nil
+}
+
+// Reads the pid from a PID file, or returns 0 if it cannot be read.
+func readPid(path string) int {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Deletes the PID file and releases its lock.
+func (p *PidFile) Remove() error {
+	if p == nil || p.f == nil {
+		return nil
+	}
+
+	// Remove while still holding the lock so we can only delete our own file; an instance which locks the removed
+	// file meanwhile sees it is no longer at the path and retries:
+	err := os.Remove(p.Path)
+	cerr := p.f.Close()
+	p.f = nil
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Like `Daemonize`, but refuses to start if another instance holds the PID file at `path`, and removes the PID
+// file on exit.
+func DaemonizePidFile(path string, start Daemon) (sig os.Signal, err error) {
+	var p *PidFile
+	p, err = CreatePidFile(path)
+	if err != nil {
+		return
+	}
+	defer p.Remove()
+
+	return Daemonize(start)
+}
@@ -0,0 +1,77 @@
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPidFileSingleInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "svc.pid")
+
+	p, err := CreatePidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("PID file holds %q", b)
+	}
+
+	_, err = CreatePidFile(path)
+	if are, ok := err.(*AlreadyRunningError); !ok || are.Pid != os.Getpid() {
+		t.Fatalf("second instance got %v", err)
+	}
+
+	if err = p.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("PID file still exists: %v", err)
+	}
+
+	// Free to start again:
+	p, err = CreatePidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Remove()
+}
+
+// An instance which opened the PID file just before its owner removed it must not count its lock on the deleted
+// file, or it and a third instance creating a new file would both run.
+func TestPidFileLockOnRemovedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("open files can't be removed on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "svc.pid")
+
+	a, err := CreatePidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err = a.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err = lockFile(b); err != nil {
+		t.Fatal(err)
+	}
+	c, err := CreatePidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Remove()
+
+	if same, err := isFileAt(b, path); err != nil || same {
+		t.Fatalf("lock on the removed file counted as the PID file: %v, %v", same, err)
+	}
+}
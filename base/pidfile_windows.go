@@ -0,0 +1,72 @@
+//go:build windows
+// +build windows
+
+package base
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var errLocked = errors.New("file is locked by another process")
+
+// Opens or creates the PID file, sharing delete access so `PidFile.Remove` can delete it while the lock is held
+// (`os.OpenFile` doesn't):
+func openPidFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		h, err := syscall.CreateFile(
+			name,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+			nil,
+			syscall.OPEN_ALWAYS,
+			syscall.FILE_ATTRIBUTE_NORMAL,
+			0,
+		)
+		if err == nil {
+			return os.NewFile(uintptr(h), path), nil
+		}
+		// A file which was removed but is still open can't be opened until it's closed:
+		if err == syscall.ERROR_ACCESS_DENIED && attempt < 10 {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+}
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// Takes an exclusive lock on `f` without blocking. Windows locks are mandatory, so the locked byte lies far past the
+// end of the file, where it doesn't stop others reading the pid; the lock is released when `f` is closed.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1, 0,
+		uintptr(unsafe.Pointer(&ol)),
+	)
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return errLocked
+	}
+	return err
+}
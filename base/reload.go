@@ -0,0 +1,69 @@
+package base
+
+import (
+	"log"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Called when a reload signal is received, e.g. to re-read config, reopen log files or re-parse templates.
+type ReloadFunc func(sig os.Signal) error
+
+var (
+	reloadLock     sync.Mutex
+	reloadHandlers = make(map[os.Signal][]ReloadFunc)
+)
+
+// Registers `f` to be called by `Daemonize` whenever `sig` is received, instead of treating `sig` as terminal.
+// Typical signals are SIGHUP, SIGUSR1 and SIGUSR2. Handlers must be registered before `Daemonize` is called and are
+// run one at a time in registration order.
+func OnReload(sig os.Signal, f ReloadFunc) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	reloadHandlers[sig] = append(reloadHandlers[sig], f)
+}
+
+// Registers `f` to be called on SIGHUP.
+func OnHangup(f ReloadFunc) {
+	OnReload(syscall.SIGHUP, f)
+}
+
+// Removes all handlers registered for `sig`.
+func ClearReload(sig os.Signal) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	delete(reloadHandlers, sig)
+}
+
+// Returns the signals with registered reload handlers:
+func reloadSignals() []os.Signal {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	sigs := make([]os.Signal, 0, len(reloadHandlers))
+	for sig := range reloadHandlers {
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// Runs the handlers registered for `sig` and returns false if there are none:
+func reload(sig os.Signal) bool {
+	reloadLock.Lock()
+	handlers := reloadHandlers[sig]
+	reloadLock.Unlock()
+
+	if len(handlers) == 0 {
+		return false
+	}
+
+	for _, f := range handlers {
+		if err := f(sig); err != nil {
+			log.Printf("reload on %s failed: %s\n", sig, err)
+		}
+	}
+	return true
+}
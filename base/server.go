@@ -1,13 +1,16 @@
 package base
 
 import (
+	"context"
 	"errors"
 	"net"
 	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type terminateSignal struct{}
@@ -19,10 +22,29 @@ func (t terminateSignal) String() string {
 func (t terminateSignal) Signal() {}
 
 type Dialable struct {
+	// Address is the first of Addresses.
 	Network, Address string
+	Addresses        []string
+	// Set for `tls://` URIs, which dial over TCP.
+	TLS *TLSOptions
+	// Retry and failover behavior of `Dial`.
+	DialOptions
+
+	tlsOnce  sync.Once
+	tlsFiles *tlsFiles
+	tlsErr   error
+
+	health dialHealth
 }
 
+// Parses a URI such as tcp://host:port, tls://host:port or unix:///path/to/socket. The host may be a
+// comma-separated list of addresses, e.g. tcp://db1:5432,db2:5432?strategy=failover&attempts=3; see `DialOptions`
+// for the query parameters.
 func ParseDialable(s string) (d *Dialable, err error) {
+	// Split off the address list, which url.Parse cannot handle:
+	var addrs []string
+	s, addrs = splitAddressList(s)
+
 	var u *url.URL
 	u, err = url.Parse(s)
 	if err != nil {
@@ -40,11 +62,30 @@ func ParseDialable(s string) (d *Dialable, err error) {
 		laddr = u.Host
 	}
 
-	return &Dialable{Network: ltype, Address: laddr}, nil
+	d = &Dialable{Network: ltype, Address: laddr}
+	if ltype == "tls" {
+		d.Network = "tcp"
+		d.TLS = parseTLSOptions(u.Query())
+	}
+	if len(addrs) > 0 {
+		d.Addresses = addrs
+	} else {
+		d.Addresses = []string{laddr}
+	}
+	if err = d.DialOptions.parse(u.Query()); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 type Listenable struct {
 	Network, Address string
+	// Set for `tls://` URIs, which listen over TCP.
+	TLS *TLSOptions
+	// Socket file permissions for `unix` URIs.
+	Unix *UnixSocketOptions
+	// Socket options applied when binding.
+	Options ListenOptions
 }
 
 func ParseListenable(s string) (l *Listenable, err error) {
@@ -55,31 +96,43 @@ func ParseListenable(s string) (l *Listenable, err error) {
 	}
 
 	var ltype, laddr string
+	var unix *UnixSocketOptions
 	ltype = u.Scheme
-	if ltype == "unix" {
+	if ltype == "unix" && isAbstractSocket(u.Opaque) {
+		// Linux abstract socket, e.g. unix:@name
+		laddr = u.Opaque
+	} else if ltype == "unix" {
 		if u.Host != "" {
 			return nil, errors.New("Listenable unix URI must have blank host, e.g. unix:///path/to/socket")
 		}
 		laddr = u.Path
+		if unix, err = parseUnixSocketOptions(u.Query()); err != nil {
+			return nil, err
+		}
+	} else if ltype == "fd" {
+		if _, err = strconv.Atoi(u.Host); err != nil {
+			return nil, errors.New("Listenable fd URI must have a file descriptor number as host, e.g. fd://3")
+		}
+		laddr = u.Host
 	} else {
 		laddr = u.Host
 	}
 
-	return &Listenable{Network: ltype, Address: laddr}, nil
+	l = &Listenable{Network: ltype, Address: laddr, Unix: unix}
+	if l.Options, err = parseListenOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if ltype == "tls" {
+		l.Network = "tcp"
+		l.TLS = parseTLSOptions(u.Query())
+	}
+	return l, nil
 }
 
 // Main method to start up a server.
 func ServeMain(la *Listenable, server func(net.Listener) error) (sig os.Signal, err error) {
-	// Create the folder for any unix sockets to live in:
-	if la.Network == "unix" {
-		// TODO(jsd): 0770 permissions on the folder?
-		// TODO(jsd): Hide mkdir error?
-		os.MkdirAll(filepath.Dir(la.Address), os.FileMode(0770)|os.ModeDir)
-	}
-
-	// Create the socket to listen on:
 	var l net.Listener
-	l, err = net.Listen(la.Network, la.Address)
+	l, err = listen(la)
 	if err != nil {
 		return
 	}
@@ -88,22 +141,72 @@ func ServeMain(la *Listenable, server func(net.Listener) error) (sig os.Signal,
 
 	// Stop listening:
 	l.Close()
+	unlisten(la)
 
+	return
+}
+
+// Creates the socket to listen on for `la`:
+func listen(la *Listenable) (net.Listener, error) {
+	if la.TLS == nil {
+		return listenSocket(la)
+	}
+
+	// Load certificates before binding so we fail early:
+	config, err := la.TLS.serverConfig()
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenSocket(la)
+	if err != nil {
+		return nil, err
+	}
+	return wrapTLS(l, config), nil
+}
+
+func listenSocket(la *Listenable) (net.Listener, error) {
+	switch la.Network {
+	case "systemd":
+		return listenSystemd(la)
+	case "fd":
+		return listenFd(la)
+	}
+
+	// Take over a socket passed down from a parent process, if any:
+	if l := adoptInherited(la); l != nil {
+		return l, nil
+	}
+
+	if la.Network == "unix" && !isAbstractSocket(la.Address) {
+		return listenUnix(la)
+	}
+
+	return bind(la)
+}
+
+// Cleans up after a listener created by `listen` has been closed:
+func unlisten(la *Listenable) {
 	// Delete the unix socket, if applicable:
-	if la.Network == "unix" {
+	if la.Network == "unix" && !isAbstractSocket(la.Address) && !handedOffTo(la.Address) {
 		os.Remove(la.Address)
 	}
-
-	return
 }
 
 type Daemon func() error
 
+// Runs `start` until it returns or a termination signal is received. Signals with handlers registered by `OnReload`
+// are dispatched to those handlers and do not end the daemon.
 func Daemonize(start Daemon) (sig os.Signal, err error) {
 	// Handle common process-killing signals so we can gracefully shut down:
 	// TODO(jsd): Go does not catch Windows' process kill signals (yet?)
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, os.Kill, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigc)
+
+	// Handle reload signals:
+	if sigs := reloadSignals(); len(sigs) > 0 {
+		signal.Notify(sigc, sigs...)
+	}
 
 	go func() {
 		// Start a server; `err` will be returned to the caller:
@@ -115,7 +218,44 @@ func Daemonize(start Daemon) (sig os.Signal, err error) {
 	}()
 
 	// Wait for a termination signal (normal or otherwise):
-	sig = <-sigc
+	for {
+		sig = <-sigc
+		if !reload(sig) {
+			break
+		}
+	}
+
+	return
+}
+
+// Returned by `DaemonizeContext` when the daemon does not return in time after its context is cancelled.
+var ErrShutdownTimeout = errors.New("daemon did not stop before the shutdown timeout")
+
+// A daemon which is told to stop by cancellation of `ctx`.
+type ContextDaemon func(ctx context.Context) error
+
+// Like `Daemonize`, but cancels the daemon's context when a termination signal is received and then waits up to
+// `timeout` for it to return so it can flush its state.
+func DaemonizeContext(start ContextDaemon, timeout time.Duration) (sig os.Signal, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	sig, _ = Daemonize(func() error {
+		err := start(ctx)
+		done <- err
+		return err
+	})
+
+	// Tell the daemon to stop and wait for it:
+	cancel()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err = <-done:
+	case <-t.C:
+		err = ErrShutdownTimeout
+	}
 
 	return
 }
@@ -0,0 +1,139 @@
+package base
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Called to stop a server from accepting new work and wait for in-flight work to finish.
+// The context expires when the drain timeout is reached.
+type ShutdownFunc func(ctx context.Context) error
+
+// Like ServeMain, but on a termination signal calls `shutdown` and gives the server up to `drainTimeout`
+// to finish in-flight work before the listener is closed.
+func ServeMainGraceful(la *Listenable, server func(net.Listener) error, shutdown ShutdownFunc, drainTimeout time.Duration) (sig os.Signal, err error) {
+	var l net.Listener
+	l, err = listen(la)
+	if err != nil {
+		return
+	}
+
+	sig, err = serveGraceful(l, server, shutdown, drainTimeout)
+
+	// Stop listening:
+	l.Close()
+	unlisten(la)
+
+	return
+}
+
+func serveGraceful(l net.Listener, server func(net.Listener) error, shutdown ShutdownFunc, drainTimeout time.Duration) (sig os.Signal, err error) {
+	done := make(chan error, 1)
+	sig, _ = Daemonize(func() error {
+		err := server(l)
+		done <- err
+		return err
+	})
+
+	if _, ok := sig.(terminateSignal); !ok {
+		// Drain in-flight work:
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		err = shutdown(ctx)
+		cancel()
+
+		// Make sure the server stops accepting even if `shutdown` did not close the listener:
+		l.Close()
+	}
+
+	// Wait for the server to return:
+	if serr := <-done; err == nil {
+		err = serr
+	}
+	return
+}
+
+// Main method to start up an HTTP server with graceful shutdown.
+// On a termination signal, the server stops accepting new connections and waits up to `drainTimeout` for active
+// connections to finish. Any connections still open after that are forcibly closed and their remote addresses
+// returned in `killed`.
+func ServeHTTPMain(la *Listenable, srv *http.Server, drainTimeout time.Duration) (sig os.Signal, killed []string, err error) {
+	var l net.Listener
+	l, err = listen(la)
+	if err != nil {
+		return
+	}
+
+	sig, killed, err = serveHTTPGraceful(l, srv, drainTimeout)
+
+	// Stop listening:
+	l.Close()
+	unlisten(la)
+
+	return
+}
+
+func serveHTTPGraceful(l net.Listener, srv *http.Server, drainTimeout time.Duration) (sig os.Signal, killed []string, err error) {
+	t := &connTracker{conns: make(map[net.Conn]http.ConnState), next: srv.ConnState}
+	srv.ConnState = t.track
+
+	sig, err = serveGraceful(
+		l,
+		func(l net.Listener) error {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+		func(ctx context.Context) error {
+			err := srv.Shutdown(ctx)
+			if err == context.DeadlineExceeded {
+				// Force-close whatever did not drain in time:
+				killed = t.open()
+				return srv.Close()
+			}
+			return err
+		},
+		drainTimeout,
+	)
+	return
+}
+
+// Tracks the open connections of an `http.Server` so that a forced close can report what it killed:
+type connTracker struct {
+	lock  sync.Mutex
+	conns map[net.Conn]http.ConnState
+	next  func(net.Conn, http.ConnState)
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.lock.Lock()
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+	default:
+		t.conns[c] = state
+	}
+	t.lock.Unlock()
+
+	if t.next != nil {
+		t.next(c, state)
+	}
+}
+
+// Returns the remote addresses of all connections not yet closed:
+func (t *connTracker) open() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	addrs := make([]string, 0, len(t.conns))
+	for c := range t.conns {
+		addrs = append(addrs, c.RemoteAddr().String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,122 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type getResult struct {
+	body string
+	err  error
+}
+
+// Serves `handler` gracefully on a local port, interrupting the process once a request has started:
+func serveAndInterrupt(t *testing.T, drainTimeout time.Duration, handler http.HandlerFunc) (os.Signal, []string, getResult, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("can't send an interrupt to the process on Windows")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		handler(w, r)
+	})}
+
+	got := make(chan getResult, 1)
+	go func() {
+		rsp, err := http.Get("http://" + l.Addr().String() + "/")
+		if err != nil {
+			got <- getResult{err: err}
+			return
+		}
+		defer rsp.Body.Close()
+		b, err := ioutil.ReadAll(rsp.Body)
+		got <- getResult{string(b), err}
+	}()
+	go func() {
+		// Serving the request means the signal handlers are installed:
+		<-started
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(os.Interrupt)
+	}()
+
+	sig, killed, err := serveHTTPGraceful(l, srv, drainTimeout)
+	return sig, killed, <-got, err
+}
+
+func TestServeHTTPGracefulDrains(t *testing.T) {
+	sig, killed, got, err := serveAndInterrupt(t, 2*time.Second, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	if sig != os.Interrupt || err != nil {
+		t.Errorf("sig %v, err %v", sig, err)
+	}
+	if len(killed) != 0 {
+		t.Errorf("killed %q", killed)
+	}
+	if got.err != nil || got.body != "done" {
+		t.Errorf("in-flight request got %q, %v", got.body, got.err)
+	}
+}
+
+func TestServeHTTPGracefulForceCloses(t *testing.T) {
+	start := time.Now()
+	sig, killed, got, err := serveAndInterrupt(t, 100*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		// Never finishes by itself:
+		<-r.Context().Done()
+	})
+
+	if sig != os.Interrupt || err != nil {
+		t.Errorf("sig %v, err %v", sig, err)
+	}
+	if len(killed) != 1 || !strings.HasPrefix(killed[0], "127.0.0.1:") {
+		t.Errorf("killed %q", killed)
+	}
+	if got.err == nil {
+		t.Errorf("in-flight request completed with %q", got.body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v to force close", elapsed)
+	}
+}
+
+func TestServeGracefulServerExits(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fail := errors.New("bind failed")
+	shutdownCalled := false
+	sig, err := serveGraceful(
+		l,
+		func(net.Listener) error { return fail },
+		func(ctx context.Context) error { shutdownCalled = true; return nil },
+		time.Second,
+	)
+	if _, ok := sig.(terminateSignal); !ok {
+		t.Errorf("sig %v", sig)
+	}
+	if err != fail {
+		t.Errorf("err %v", err)
+	}
+	if shutdownCalled {
+		t.Error("shutdown called after the server exited by itself")
+	}
+}
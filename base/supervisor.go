@@ -0,0 +1,401 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type RestartPolicy int
+
+const (
+	// Never restart the child once it returns.
+	RestartNever RestartPolicy = iota
+	// Restart the child only if it returns an error or panics.
+	RestartOnFailure
+	// Always restart the child when it returns.
+	RestartAlways
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// A named daemon run by a `Supervisor`.
+type Child struct {
+	Name string
+	// Either `Start` or `StartContext` must be set. The context passed to `StartContext` is cancelled on shutdown.
+	Start        Daemon
+	StartContext ContextDaemon
+	Restart      RestartPolicy
+	// Optional; called on shutdown to make `Start` return. Children with neither `Stop` nor `StartContext` are
+	// abandoned on shutdown.
+	Stop func() error
+	// If set, the whole supervisor shuts down when this child stops for good.
+	Essential bool
+	// Restart delays double from MinBackoff up to MaxBackoff, and reset once the child stays up for MaxBackoff.
+	MinBackoff, MaxBackoff time.Duration
+	// Optional; called once the child has been started and should return when it's ready to serve, e.g. once its port
+	// accepts connections. The next child isn't started until it returns. An error aborts startup and shuts the
+	// supervisor down. The context is cancelled on shutdown.
+	Ready func(ctx context.Context) error
+}
+
+type ChildState int
+
+const (
+	ChildPending ChildState = iota
+	ChildRunning
+	ChildBackoff
+	ChildStopped
+	ChildFailed
+)
+
+func (s ChildState) String() string {
+	switch s {
+	case ChildPending:
+		return "pending"
+	case ChildRunning:
+		return "running"
+	case ChildBackoff:
+		return "backoff"
+	case ChildStopped:
+		return "stopped"
+	case ChildFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ChildState(%d)", int(s))
+	}
+}
+
+// Snapshot of a child's state as reported by `Supervisor.Status`.
+type ChildStatus struct {
+	Name      string
+	State     ChildState
+	Since     time.Time
+	Restarts  int
+	LastError error
+}
+
+type supervised struct {
+	Child
+
+	lock   sync.Mutex
+	status ChildStatus
+	exited chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *supervised) set(state ChildState) {
+	c.lock.Lock()
+	c.status.State = state
+	c.status.Since = time.Now()
+	c.lock.Unlock()
+}
+
+func (c *supervised) backoffBounds() (min, max time.Duration) {
+	min, max = c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max < min {
+		max = defaultMaxBackoff
+		if max < min {
+			max = min
+		}
+	}
+	return
+}
+
+// Runs a set of named daemons with per-child restart policies. Children are started in order, each once the one
+// before it is ready (see `Child.Ready`), and stopped in reverse order.
+type Supervisor struct {
+	children []*supervised
+
+	lock    sync.Mutex
+	started bool
+	err     error
+	stopc   chan struct{}
+	stopped sync.Once
+	done    chan struct{}
+}
+
+func NewSupervisor(children ...Child) *Supervisor {
+	s := &Supervisor{
+		children: make([]*supervised, len(children)),
+		stopc:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for i, c := range children {
+		s.children[i] = &supervised{
+			Child:  c,
+			status: ChildStatus{Name: c.Name, State: ChildPending, Since: time.Now()},
+			exited: make(chan struct{}),
+		}
+		s.children[i].ctx, s.children[i].cancel = context.WithCancel(context.Background())
+	}
+	return s
+}
+
+// Starts all children and returns once they have all stopped for good, an essential child stops, or `Stop` is
+// called. Returns the error of the essential child that caused shutdown, if any.
+func (s *Supervisor) Run() error {
+	s.lock.Lock()
+	if s.started {
+		s.lock.Unlock()
+		return errors.New("supervisor already started")
+	}
+	s.started = true
+	s.lock.Unlock()
+	defer close(s.done)
+
+	// `Stop` may have been called before we got here:
+	if s.stopping() {
+		return s.Err()
+	}
+
+	started := 0
+	for _, c := range s.children {
+		if s.stopping() {
+			break
+		}
+		go s.supervise(c)
+		started++
+		if err := s.waitReady(c); err != nil {
+			s.requestStop(err)
+			break
+		}
+	}
+	// Children which were never started have nothing to stop:
+	for _, c := range s.children[started:] {
+		c.set(ChildStopped)
+		close(c.exited)
+	}
+
+	allExited := make(chan struct{})
+	go func() {
+		for _, c := range s.children {
+			<-c.exited
+		}
+		close(allExited)
+	}()
+
+	select {
+	case <-s.stopc:
+	case <-allExited:
+	}
+	s.requestStop(nil)
+
+	// Stop children in reverse order:
+	for i := len(s.children) - 1; i >= 0; i-- {
+		c := s.children[i]
+		c.cancel()
+		if exited(c) {
+			continue
+		}
+
+		if c.Stop != nil {
+			if err := c.Stop(); err != nil {
+				log.Printf("supervisor: stopping %s: %s\n", c.Name, err)
+			}
+		} else if c.StartContext == nil {
+			continue
+		}
+		<-c.exited
+	}
+
+	return s.Err()
+}
+
+func (s *Supervisor) supervise(c *supervised) {
+	defer close(c.exited)
+
+	min, max := c.backoffBounds()
+	backoff := min
+	for {
+		c.set(ChildRunning)
+		started := time.Now()
+		err := c.run()
+
+		c.lock.Lock()
+		c.status.LastError = err
+		c.lock.Unlock()
+
+		if s.stopping() {
+			c.set(ChildStopped)
+			return
+		}
+		if !(c.Restart == RestartAlways || (c.Restart == RestartOnFailure && err != nil)) {
+			if err != nil {
+				c.set(ChildFailed)
+				log.Printf("supervisor: %s failed: %s\n", c.Name, err)
+			} else {
+				c.set(ChildStopped)
+			}
+			if c.Essential {
+				if err == nil {
+					err = errors.New("essential child stopped")
+				}
+				s.requestStop(fmt.Errorf("%s: %s", c.Name, err))
+			}
+			return
+		}
+
+		// Back off before restarting, unless the child stayed up for a while:
+		if time.Since(started) >= max {
+			backoff = min
+		}
+		if err != nil {
+			log.Printf("supervisor: %s failed, restarting in %s: %s\n", c.Name, backoff, err)
+		}
+		c.set(ChildBackoff)
+		select {
+		case <-time.After(backoff):
+		case <-s.stopc:
+			c.set(ChildStopped)
+			return
+		}
+
+		backoff *= 2
+		if backoff > max {
+			backoff = max
+		}
+		c.lock.Lock()
+		c.status.Restarts++
+		c.lock.Unlock()
+	}
+}
+
+// Waits for a started child to become ready. Returns nil if it has no `Ready` probe or the supervisor is stopping:
+func (s *Supervisor) waitReady(c *supervised) error {
+	if c.Ready == nil {
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		var err error
+		if p := TryPanic(func() { err = c.Ready(c.ctx) }); p != nil {
+			err = fmt.Errorf("panic: %s at %s", p.Error(), p.TopFrame())
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("%s: not ready: %s", c.Name, err)
+		}
+		return nil
+	case <-c.exited:
+		return fmt.Errorf("%s: stopped before becoming ready", c.Name)
+	case <-s.stopc:
+		return nil
+	}
+}
+
+// Runs the child once, converting a panic into an error:
+func (c *supervised) run() (err error) {
+	p := TryPanic(func() {
+		if c.StartContext != nil {
+			err = c.StartContext(c.ctx)
+		} else {
+			err = c.Start()
+		}
+	})
+	if p != nil {
+		return fmt.Errorf("panic: %s at %s", p.Error(), p.TopFrame())
+	}
+	return
+}
+
+func exited(c *supervised) bool {
+	select {
+	case <-c.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) stopping() bool {
+	select {
+	case <-s.stopc:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) requestStop(err error) {
+	s.stopped.Do(func() {
+		s.lock.Lock()
+		s.err = err
+		s.lock.Unlock()
+		close(s.stopc)
+	})
+}
+
+// Stops all children in reverse order and waits for `Run` to return.
+func (s *Supervisor) Stop() {
+	s.requestStop(nil)
+
+	s.lock.Lock()
+	started := s.started
+	s.lock.Unlock()
+	if started {
+		<-s.done
+	}
+}
+
+// Returns the error of the essential child that caused shutdown, if any.
+func (s *Supervisor) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Returns a snapshot of the state of every child, in startup order.
+func (s *Supervisor) Status() []ChildStatus {
+	status := make([]ChildStatus, len(s.children))
+	for i, c := range s.children {
+		c.lock.Lock()
+		status[i] = c.status
+		c.lock.Unlock()
+	}
+	return status
+}
+
+// Like `Run`, but also stops all children when `ctx` is cancelled.
+func (s *Supervisor) RunContext(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.done:
+		}
+	}()
+	return s.Run()
+}
+
+// Returns a `Child` which runs this supervisor, for building trees of supervisors.
+func (s *Supervisor) AsChild(name string) Child {
+	return Child{
+		Name:         name,
+		StartContext: s.RunContext,
+	}
+}
+
+// Runs the supervisor under `Daemonize`, stopping all children on a termination signal.
+func (s *Supervisor) Main() (sig os.Signal, err error) {
+	sig, _ = Daemonize(s.Run)
+	s.Stop()
+	return sig, s.Err()
+}
@@ -0,0 +1,87 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisorOrderedStartup(t *testing.T) {
+	var lock sync.Mutex
+	var events []string
+	record := func(e string) {
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	}
+
+	child := func(name string, delay time.Duration) Child {
+		ready := make(chan struct{})
+		return Child{
+			Name: name,
+			StartContext: func(ctx context.Context) error {
+				record("start " + name)
+				time.Sleep(delay)
+				record("ready " + name)
+				close(ready)
+				<-ctx.Done()
+				return nil
+			},
+			Ready: func(ctx context.Context) error {
+				select {
+				case <-ready:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		}
+	}
+
+	s := NewSupervisor(child("db", 30*time.Millisecond), child("http", 10*time.Millisecond), child("worker", 0))
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	want := "start db,ready db,start http,ready http,start worker,ready worker"
+	if got := strings.Join(events, ","); got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestSupervisorNotReady(t *testing.T) {
+	started := make(chan string, 2)
+	s := NewSupervisor(
+		Child{
+			Name:         "db",
+			StartContext: func(ctx context.Context) error { started <- "db"; <-ctx.Done(); return nil },
+			Ready:        func(ctx context.Context) error { return errors.New("no connection") },
+		},
+		Child{
+			Name:         "http",
+			StartContext: func(ctx context.Context) error { started <- "http"; <-ctx.Done(); return nil },
+		},
+	)
+
+	err := s.Run()
+	if err == nil || !strings.Contains(err.Error(), "db: not ready: no connection") {
+		t.Fatalf("got error %v", err)
+	}
+	close(started)
+	for name := range started {
+		if name != "db" {
+			t.Errorf("%s was started after db failed to become ready", name)
+		}
+	}
+	if st := s.Status()[1].State; st != ChildStopped {
+		t.Errorf("http state is %s", st)
+	}
+}
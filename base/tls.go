@@ -0,0 +1,220 @@
+package base
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often certificate files are checked for changes on disk:
+const TLSReloadInterval = 5 * time.Second
+
+// TLS settings for `tls://` listenables and dialables, taken from the URI query.
+type TLSOptions struct {
+	// Certificate and private key, PEM encoded; required for listeners, optional (client certificate) for dialers.
+	CertFile, KeyFile string
+	// Listener only; when set, clients must present a certificate signed by one of these CAs.
+	ClientCAFile string
+	// Dialer only; CAs to verify the server with instead of the system roots.
+	CAFile string
+	// Dialer only; overrides the host name used to verify the server certificate.
+	ServerName string
+}
+
+func parseTLSOptions(q url.Values) *TLSOptions {
+	return &TLSOptions{
+		CertFile:     q.Get("cert"),
+		KeyFile:      q.Get("key"),
+		ClientCAFile: q.Get("client_ca"),
+		CAFile:       q.Get("ca"),
+		ServerName:   q.Get("server_name"),
+	}
+}
+
+// Keeps a certificate and CA pool loaded from disk, reloading them when the files change.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+
+	lock    sync.Mutex
+	checked time.Time
+	modTime time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newTLSFiles(certFile, keyFile, caFile string) (*tlsFiles, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("TLS URI must have both 'cert' and 'key' or neither")
+	}
+
+	f := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Returns the latest modification time of all files:
+func (f *tlsFiles) latest() (t time.Time, err error) {
+	for _, name := range []string{f.certFile, f.keyFile, f.caFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return t, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return
+}
+
+func (f *tlsFiles) load() error {
+	modTime, err := f.latest()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if f.certFile != "" {
+		c, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if f.caFile != "" {
+		pem, err := ioutil.ReadFile(f.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in '%s'", f.caFile)
+		}
+	}
+
+	f.lock.Lock()
+	f.cert, f.pool, f.modTime, f.checked = cert, pool, modTime, time.Now()
+	f.lock.Unlock()
+	return nil
+}
+
+// Returns the current certificate and CA pool, reloading them first if the files changed on disk.
+// A failed reload is logged and the previously loaded files are kept.
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.lock.Lock()
+	stale := time.Since(f.checked) >= TLSReloadInterval
+	if stale {
+		f.checked = time.Now()
+	}
+	cert, pool, modTime := f.cert, f.pool, f.modTime
+	f.lock.Unlock()
+
+	if !stale {
+		return cert, pool
+	}
+
+	if t, err := f.latest(); err != nil || !t.After(modTime) {
+		return cert, pool
+	}
+	if err := f.load(); err != nil {
+		log.Printf("TLS reload of '%s' failed: %s\n", f.certFile, err)
+		return cert, pool
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.cert, f.pool
+}
+
+// Builds a server config which picks up certificate changes on disk and requires client certificates if a client
+// CA is configured.
+func (o *TLSOptions) serverConfig() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("TLS listenable URI must have 'cert' and 'key' query parameters")
+	}
+
+	files, err := newTLSFiles(o.CertFile, o.KeyFile, o.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := files.current()
+
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				c.ClientCAs = pool
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return c, nil
+		},
+	}, nil
+}
+
+// Builds a client config for connecting to `address`, presenting a client certificate if one is configured.
+func (o *TLSOptions) clientConfig(files *tlsFiles, address string) *tls.Config {
+	cert, pool := files.current()
+
+	serverName := o.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    pool,
+	}
+	if cert != nil {
+		c.Certificates = []tls.Certificate{*cert}
+	}
+	return c
+}
+
+// Wraps a TLS listener so the underlying socket can still be passed to `Reexec`.
+type tlsListener struct {
+	net.Listener
+	inner net.Listener
+}
+
+func (l *tlsListener) File() (*os.File, error) {
+	fl, ok := l.inner.(filer)
+	if !ok {
+		return nil, fmt.Errorf("listener on %s cannot be passed to a child process", l.Addr())
+	}
+	return fl.File()
+}
+
+func wrapTLS(l net.Listener, config *tls.Config) net.Listener {
+	return &tlsListener{Listener: tls.NewListener(l, config), inner: l}
+}
+
+// Lazily loads the client certificate and CAs for a `tls://` dialable:
+func (d *Dialable) tlsConfig(address string) (*tls.Config, error) {
+	d.tlsOnce.Do(func() {
+		d.tlsFiles, d.tlsErr = newTLSFiles(d.TLS.CertFile, d.TLS.KeyFile, d.TLS.CAFile)
+	})
+	if d.tlsErr != nil {
+		return nil, d.tlsErr
+	}
+	return d.TLS.clientConfig(d.tlsFiles, address), nil
+}
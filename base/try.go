@@ -1,32 +1,147 @@
 package base
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
-	//"runtime/debug"
+	"strconv"
+	"strings"
 )
 
+// Initial buffer size for capturing stack traces; grown as needed.
 const StackStringSize = 16384
 
-// Attempts to run `attempt` and recovers from any panics, returning the panic object or nil if success.
-func Try(attempt func()) (panicked interface{}, stackTrace string) {
-	defer func() {
-		if o := recover(); o != nil {
-			panicked = o
+// A single stack frame of a recovered panic.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+}
+
+// Details of a panic recovered by `TryPanic`.
+type Panic struct {
+	// The value passed to `panic`.
+	Value interface{}
+	// `Value` if it is an error, followed by each error it wraps.
+	Errors []error
+	// ID of the goroutine that panicked.
+	GoroutineID int64
+	// Call stack starting at the function that panicked.
+	Frames []Frame
+	// Full text of the goroutine's stack trace as printed by `runtime.Stack`.
+	Stack string
+}
 
-			// Get stack trace:
-			stkBytes := make([]byte, StackStringSize, StackStringSize)
-			n := runtime.Stack(stkBytes, false)
-			stackTrace = string(stkBytes[:n])
+func (p *Panic) Error() string {
+	if len(p.Errors) > 0 {
+		return p.Errors[0].Error()
+	}
+	return fmt.Sprint(p.Value)
+}
 
-			return
+// Returns the panic value if it is an error, for use with `errors.Is` and `errors.As`.
+func (p *Panic) Unwrap() error {
+	if len(p.Errors) > 0 {
+		return p.Errors[0]
+	}
+	return nil
+}
+
+// Returns the frame that panicked, useful for grouping panics, or a zero Frame if unknown.
+func (p *Panic) TopFrame() Frame {
+	if len(p.Frames) == 0 {
+		return Frame{}
+	}
+	return p.Frames[0]
+}
+
+// Attempts to run `attempt` and recovers from any panics, returning the panic details or nil if success.
+func TryPanic(attempt func()) (p *Panic) {
+	defer func() {
+		if o := recover(); o != nil {
+			p = newPanic(o)
 		}
 	}()
 
 	attempt()
+	return nil
+}
 
-	panicked = nil
-	stackTrace = ""
-	return
+// Attempts to run `attempt` and recovers from any panics, returning the panic object or nil if success.
+func Try(attempt func()) (panicked interface{}, stackTrace string) {
+	p := TryPanic(attempt)
+	if p == nil {
+		return nil, ""
+	}
+	return p.Value, p.Stack
+}
+
+// Must be called directly from the deferred func which recovered `o`.
+func newPanic(o interface{}) *Panic {
+	p := &Panic{Value: o}
+
+	// Unwrap the error chain:
+	if err, ok := o.(error); ok {
+		for ; err != nil; err = errors.Unwrap(err) {
+			p.Errors = append(p.Errors, err)
+		}
+	}
+
+	// Skip runtime.Callers, newPanic and the deferred func:
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(3, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, len(pcs)*2)
+	}
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		// Skip runtime.gopanic et al. above the function that panicked:
+		if len(p.Frames) > 0 || !strings.HasPrefix(f.Function, "runtime.") {
+			p.Frames = append(p.Frames, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+
+	p.Stack = stack()
+	p.GoroutineID = goroutineID(p.Stack)
+	return p
+}
+
+// Returns the current goroutine's full stack trace:
+func stack() string {
+	buf := make([]byte, StackStringSize)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// Parses the goroutine ID from the "goroutine 123 [running]:" header of a stack trace:
+func goroutineID(stack string) int64 {
+	s := strings.TrimPrefix(stack, "goroutine ")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
 }
 
 func PanicIf(err error) {
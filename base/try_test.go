@@ -0,0 +1,104 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+//go:noinline
+func panicWith(v interface{}) {
+	panic(v)
+}
+
+func TestTryPanic(t *testing.T) {
+	if p := TryPanic(func() {}); p != nil {
+		t.Fatalf("got %v without a panic", p)
+	}
+
+	wrapped := fmt.Errorf("reading config: %w", io.ErrUnexpectedEOF)
+	p := TryPanic(func() { panicWith(wrapped) })
+	if p == nil {
+		t.Fatal("no panic recovered")
+	}
+	if p.Value != wrapped || p.Error() != wrapped.Error() {
+		t.Errorf("value %v", p.Value)
+	}
+	if len(p.Errors) != 2 || p.Errors[1] != io.ErrUnexpectedEOF {
+		t.Errorf("errors %v", p.Errors)
+	}
+	if !errors.Is(p, io.ErrUnexpectedEOF) {
+		t.Error("errors.Is doesn't see the wrapped error")
+	}
+
+	// Frames start at the function that panicked:
+	top := p.TopFrame()
+	if !strings.HasSuffix(top.Function, ".panicWith") || !strings.HasSuffix(top.File, "try_test.go") || top.Line == 0 {
+		t.Errorf("top frame %v", top)
+	}
+	if len(p.Frames) < 2 || !strings.Contains(p.Frames[1].Function, "TestTryPanic") {
+		t.Errorf("frames %v", p.Frames)
+	}
+	if !strings.Contains(p.Stack, "panicWith") {
+		t.Errorf("stack %q", p.Stack)
+	}
+}
+
+func TestTryPanicValue(t *testing.T) {
+	p := TryPanic(func() { panicWith(42) })
+	if p == nil || p.Value != 42 || p.Errors != nil || p.Error() != "42" || p.Unwrap() != nil {
+		t.Fatalf("got %+v", p)
+	}
+
+	v, stack := Try(func() { panicWith("boom") })
+	if v != "boom" || !strings.HasPrefix(stack, "goroutine ") {
+		t.Errorf("Try: %v %q", v, stack)
+	}
+	if v, stack = Try(func() {}); v != nil || stack != "" {
+		t.Errorf("Try without a panic: %v %q", v, stack)
+	}
+}
+
+func TestTryPanicGoroutineID(t *testing.T) {
+	ids := make(chan int64, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ids <- TryPanic(func() { panicWith("x") }).GoroutineID
+		}()
+	}
+	a, b := <-ids, <-ids
+	if a <= 0 || b <= 0 || a == b {
+		t.Errorf("goroutine IDs %d and %d", a, b)
+	}
+
+	// The ID is the one in the stack header:
+	buf := make([]byte, 64)
+	header := string(buf[:runtime.Stack(buf, false)])
+	if id := goroutineID(header); id <= 0 || !strings.HasPrefix(header, fmt.Sprintf("goroutine %d ", id)) {
+		t.Errorf("parsed %d from %q", id, header)
+	}
+}
+
+func TestGoroutineID(t *testing.T) {
+	for stack, want := range map[string]int64{
+		"goroutine 1 [running]:\nmain.main()": 1,
+		"goroutine 123456 [chan receive]:":    123456,
+		"goroutine x [running]:":              0,
+		"":                                    0,
+	} {
+		if got := goroutineID(stack); got != want {
+			t.Errorf("goroutineID(%q) = %d, want %d", stack, got, want)
+		}
+	}
+}
+
+func TestPanicIf(t *testing.T) {
+	PanicIf(nil)
+	err := errors.New("fail")
+	if p := TryPanic(func() { PanicIf(err) }); p == nil || p.Value != err {
+		t.Errorf("got %v", p)
+	}
+}
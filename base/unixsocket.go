@@ -0,0 +1,150 @@
+package base
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// How long to wait for a live server to answer on an existing unix socket before treating it as stale:
+const staleSocketProbeTimeout = time.Second
+
+// Permissions applied to a unix socket after it is created, taken from the URI query, e.g.
+// unix:///run/app/app.sock?mode=0660&owner=app&group=www-data
+type UnixSocketOptions struct {
+	// Zero to leave the mode as created.
+	Mode os.FileMode
+	// User and group names or numeric ids; blank to leave unchanged.
+	Owner, Group string
+}
+
+func parseUnixSocketOptions(q url.Values) (*UnixSocketOptions, error) {
+	o := &UnixSocketOptions{
+		Owner: q.Get("owner"),
+		Group: q.Get("group"),
+	}
+	if m := q.Get("mode"); m != "" {
+		mode, err := strconv.ParseUint(m, 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("Listenable unix URI has invalid octal mode '%s'", m)
+		}
+		o.Mode = os.FileMode(mode)
+	}
+	return o, nil
+}
+
+func lookupUid(owner string) (int, error) {
+	if owner == "" {
+		return -1, nil
+	}
+	if uid, err := strconv.Atoi(owner); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGid(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// Removes a socket file left behind by a crashed process. Fails if a live server still answers on it or if the path
+// is not a socket.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("'%s' already exists and is not a unix socket", path)
+	}
+
+	c, err := net.DialTimeout("unix", path, staleSocketProbeTimeout)
+	if err == nil {
+		c.Close()
+		return fmt.Errorf("unix socket '%s' is in use by another process", path)
+	}
+	if !isConnRefused(err) {
+		return fmt.Errorf("could not probe unix socket '%s': %s", path, err)
+	}
+
+	return os.Remove(path)
+}
+
+func isConnRefused(err error) bool {
+	if oerr, ok := err.(*net.OpError); ok {
+		if serr, ok := oerr.Err.(*os.SyscallError); ok {
+			return serr.Err == syscall.ECONNREFUSED
+		}
+	}
+	return false
+}
+
+// Creates a unix socket listener, preparing its folder and removing a stale socket first, then applies its permissions.
+func listenUnix(la *Listenable) (net.Listener, error) {
+	// Create the folder for the socket to live in:
+	if err := os.MkdirAll(filepath.Dir(la.Address), os.FileMode(0770)|os.ModeDir); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(la.Address); err != nil {
+		return nil, err
+	}
+
+	l, err := bind(la)
+	if err != nil {
+		return nil, err
+	}
+	if la.Unix == nil {
+		return l, nil
+	}
+
+	if err = la.Unix.apply(la.Address); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (o *UnixSocketOptions) apply(path string) error {
+	uid, err := lookupUid(o.Owner)
+	if err != nil {
+		return err
+	}
+	gid, err := lookupGid(o.Group)
+	if err != nil {
+		return err
+	}
+
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	if o.Mode != 0 {
+		if err = os.Chmod(path, o.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
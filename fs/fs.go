@@ -4,20 +4,18 @@ import (
 	"mime"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
+
+	"github.com/JamesDunne/go-util/base"
 )
 
 func CanonicalPath(path string) string {
-	abs, err := filepath.Abs(path)
-	if err != nil {
-		panic(err)
-	}
-	abs, err = filepath.EvalSymlinks(abs)
-	if err != nil {
-		panic(err)
-	}
-	return abs
+	return base.CanonicalPath(path)
+}
+
+// Like `CanonicalPath` but returns errors, e.g. if the path does not exist, instead of panicking.
+func CanonicalPathErr(path string) (string, error) {
+	return base.CanonicalPathErr(path)
 }
 
 func GetMimeType(filename string) string {
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type errorStatus struct {
+	err        error
+	statusCode int
+}
+
+var (
+	errorStatusLock sync.RWMutex
+	// Checked in order with `errors.Is`; later registrations take precedence.
+	errorStatuses = []errorStatus{
+		{sql.ErrNoRows, http.StatusNotFound},
+		{os.ErrNotExist, http.StatusNotFound},
+		{os.ErrPermission, http.StatusForbidden},
+		{os.ErrDeadlineExceeded, http.StatusGatewayTimeout},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{http.ErrHandlerTimeout, http.StatusServiceUnavailable},
+		{http.ErrMissingFile, http.StatusBadRequest},
+	}
+)
+
+// Maps errors matching `sentinel` (by `errors.Is`) to `statusCode` for `StatusCodeOf` and `FromError`.
+func RegisterErrorStatus(sentinel error, statusCode int) {
+	errorStatusLock.Lock()
+	defer errorStatusLock.Unlock()
+	errorStatuses = append(errorStatuses, errorStatus{sentinel, statusCode})
+}
+
+// Returns the status code for `err`: that of an `HttpError` or `*Error` in its chain, else that of the last
+// registered sentinel it matches.
+func StatusCodeOf(err error) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	var herr HttpError
+	if errors.As(err, &herr) && herr.StatusCode != 0 {
+		return herr.StatusCode, true
+	}
+	var rerr ResponseError
+	if errors.As(err, &rerr) && rerr.Err != nil && rerr.Err.StatusCode != 0 {
+		return rerr.Err.StatusCode, true
+	}
+
+	errorStatusLock.RLock()
+	defer errorStatusLock.RUnlock()
+	for i := len(errorStatuses) - 1; i >= 0; i-- {
+		if errors.Is(err, errorStatuses[i].err) {
+			return errorStatuses[i].statusCode, true
+		}
+	}
+	return 0, false
+}
+
+// Converts `err` to an `*Error`, returning the original if it wraps one and otherwise using the status code from
+// `StatusCodeOf`, or 500.
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var rerr ResponseError
+	if errors.As(err, &rerr) && rerr.Err != nil {
+		return rerr.Err
+	}
+
+	statusCode, ok := StatusCodeOf(err)
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+	return AsError(err, statusCode)
+}
+
+// Returns the wrapped error, if any.
+func (e *Error) Unwrap() error {
+	if e == nil {
+		return nil
+	}
+	return e.Error
+}
+
+// Returns the error as an `error` value for use in error chains; recover it with `FromError`.
+// `Error` can't implement `error` itself since its `Error` field would clash with the method.
+func (e *Error) Err() error {
+	if e == nil {
+		return nil
+	}
+	return ResponseError{Err: e}
+}
+
+// Carries an `*Error` through code which deals in plain `error`s.
+type ResponseError struct {
+	Err *Error
+}
+
+func (e ResponseError) Error() string {
+	if e.Err == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%d %s", e.Err.StatusCode, e.Err.message())
+}
+
+func (e ResponseError) Unwrap() error {
+	return e.Err.Unwrap()
+}
+
+// Returns the wrapped error, if any.
+func (e HttpError) Unwrap() error {
+	return e.TheError
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"github.com/JamesDunne/go-util/base"
 	"log"
@@ -11,6 +12,8 @@ type HttpError struct {
 	StatusCode  int
 	UserMessage string
 	TheError    error
+	// Optional RFC 7807 details to respond with instead of the plain user message.
+	Problem *ProblemDetails
 }
 
 func NewHttpError(status int, userMessage string, err error) HttpError {
@@ -18,7 +21,13 @@ func NewHttpError(status int, userMessage string, err error) HttpError {
 }
 
 func (e HttpError) Error() string {
-	return e.TheError.Error()
+	if e.TheError != nil {
+		return e.TheError.Error()
+	}
+	if e.UserMessage != "" {
+		return e.UserMessage
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
 }
 
 func (e HttpError) String() string {
@@ -26,14 +35,18 @@ func (e HttpError) String() string {
 }
 
 func getErrorDetails(panicked interface{}, stackTrace string) (statusCode int, userMessage string, logError string) {
-	if herr, ok := panicked.(HttpError); ok {
+	if herr, ok := asHttpError(panicked); ok {
 		logError = fmt.Sprintf("%s\n  STACK: %s", herr.Error(), stackTrace)
 		userMessage = herr.UserMessage
 		statusCode = herr.StatusCode
 	} else if err, ok := panicked.(error); ok {
 		logError = fmt.Sprintf("%s\n  STACK: %s", err.Error(), stackTrace)
-		userMessage = "500 Internal Server Error"
-		statusCode = http.StatusInternalServerError
+		// Well-known errors get their own status; don't leak their messages though:
+		statusCode, ok = StatusCodeOf(err)
+		if !ok {
+			statusCode = http.StatusInternalServerError
+		}
+		userMessage = fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
 	} else {
 		logError = fmt.Sprintf("%s\n STACK: %s", panicked, stackTrace)
 		userMessage = "500 Internal Server Error"
@@ -42,6 +55,31 @@ func getErrorDetails(panicked interface{}, stackTrace string) (statusCode int, u
 	return
 }
 
+// Finds an `HttpError` panicked directly or wrapped in a panicked error:
+func asHttpError(panicked interface{}) (herr HttpError, ok bool) {
+	if herr, ok = panicked.(HttpError); ok {
+		return
+	}
+	if err, isErr := panicked.(error); isErr {
+		ok = errors.As(err, &herr)
+	}
+	return
+}
+
+// Returns the problem details of a panicked `HttpError`, defaulting the detail to the user message:
+func getProblem(panicked interface{}, userMessage string) *ProblemDetails {
+	herr, ok := asHttpError(panicked)
+	if !ok || herr.Problem == nil {
+		return nil
+	}
+
+	p := *herr.Problem
+	if p.Detail == "" {
+		p.Detail = userMessage
+	}
+	return &p
+}
+
 type HttpErrorHandler struct {
 	handler http.HandlerFunc
 }
@@ -61,6 +99,10 @@ func (h HttpErrorHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request)
 		statusCode, userMessage, logError := getErrorDetails(pnk, stackTrace)
 
 		log.Printf("ERROR: %s\n", logError)
+		if p := getProblem(pnk, userMessage); p != nil {
+			WriteProblem(rsp, statusCode, p)
+			return
+		}
 		http.Error(rsp, userMessage, statusCode)
 		return
 	}
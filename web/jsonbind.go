@@ -0,0 +1,425 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Validation errors keyed by field name, as found by `Validate`.
+type FieldErrors map[string][]string
+
+func (fe FieldErrors) Add(field, message string) {
+	fe[field] = append(fe[field], message)
+}
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for f := range fe {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, 0, len(fe))
+	for _, f := range fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f, strings.Join(fe[f], ", ")))
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// Implement this on input types for validation beyond struct tags; it is called after the tags pass. Return
+// `FieldErrors` to report per-field errors.
+type Validator interface {
+	Validate() error
+}
+
+// Decodes JSON request bodies for `BindJSON` and typed JSON handlers.
+type JsonBinder struct {
+	// Larger bodies are rejected with 413. Zero means no limit.
+	MaxBodyBytes int64
+	// Accept fields in the body which the input type doesn't have.
+	AllowUnknownFields bool
+}
+
+var DefaultJsonBinder = JsonBinder{MaxBodyBytes: 1 << 20}
+
+// Decodes the request body into `v` with `DefaultJsonBinder` and validates it.
+func BindJSON(req *http.Request, v interface{}) *Error {
+	return DefaultJsonBinder.Bind(req, v)
+}
+
+// Decodes the request body into `v` and validates it. Returns a 415 for a non-JSON content type, 413 for an
+// oversized body, 400 for a malformed body and 422 for a body which fails validation; field errors are attached as
+// problem details.
+func (b JsonBinder) Bind(req *http.Request, v interface{}) *Error {
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || !(mt == "application/json" || strings.HasSuffix(mt, "+json")) {
+			return AsError(fmt.Errorf("unsupported content type '%s'; expected application/json", ct), http.StatusUnsupportedMediaType)
+		}
+	}
+
+	body := io.Reader(req.Body)
+	if b.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(nil, req.Body, b.MaxBodyBytes)
+	}
+
+	dec := json.NewDecoder(body)
+	if !b.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	// Only a single JSON value is allowed:
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("request body must contain a single JSON value")
+		}
+		return decodeError(err)
+	}
+
+	if fe := Validate(v); fe != nil {
+		return validationError(fe)
+	}
+	if vv, ok := v.(Validator); ok {
+		if err := vv.Validate(); err != nil {
+			return validationError(err)
+		}
+	}
+	return nil
+}
+
+func decodeError(err error) *Error {
+	var (
+		mbe *http.MaxBytesError
+		se  *json.SyntaxError
+		ute *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &mbe):
+		return AsError(fmt.Errorf("request body is larger than %d bytes", mbe.Limit), http.StatusRequestEntityTooLarge)
+	case err == io.EOF:
+		return AsError(errors.New("request body is empty"), http.StatusBadRequest)
+	case err == io.ErrUnexpectedEOF:
+		return AsError(errors.New("request body is truncated JSON"), http.StatusBadRequest)
+	case errors.As(err, &se):
+		return AsError(fmt.Errorf("malformed JSON at offset %d: %s", se.Offset, se), http.StatusBadRequest)
+	case errors.As(err, &ute) && ute.Field != "":
+		return AsError(err, http.StatusBadRequest).WithFieldError(ute.Field, fmt.Sprintf("must be of type %s, not a JSON %s", ute.Type, ute.Value))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for this:
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return AsError(err, http.StatusBadRequest).WithFieldError(field, "unknown field")
+	default:
+		return AsError(err, http.StatusBadRequest)
+	}
+}
+
+func validationError(err error) *Error {
+	werr := AsError(err, http.StatusUnprocessableEntity)
+	if fe, ok := err.(FieldErrors); ok {
+		werr.Problem = &ProblemDetails{Title: "Validation failed", Detail: "One or more fields are invalid.", Errors: fe}
+	}
+	return werr
+}
+
+// Converts the error into an `HttpError` for `writeJsonError`:
+func (e *Error) httpError() HttpError {
+	return HttpError{StatusCode: e.StatusCode, UserMessage: e.message(), TheError: e.Error, Problem: e.Problem}
+}
+
+// Returned from a `JsonHandlerFunc` in place of a result to respond with the error; unlike panicking, this is cheap
+// enough for every bad request body:
+type rejectedRequest struct {
+	*Error
+}
+
+// Handles requests with a JSON body decoded into `*In`.
+type TypedJsonHandlerFunc[In any] func(req *http.Request, in *In) interface{}
+
+// Creates a `JsonHandler` which binds the request body with `DefaultJsonBinder` before calling `handler`.
+func NewTypedJsonHandler[In any](handler TypedJsonHandlerFunc[In]) JsonHandler {
+	return NewTypedJsonHandlerWith(DefaultJsonBinder, handler)
+}
+
+// Creates a `JsonHandler` which binds the request body with `b` before calling `handler`. Panics if the `validate`
+// tags of `In` are invalid.
+func NewTypedJsonHandlerWith[In any](b JsonBinder, handler TypedJsonHandlerFunc[In]) JsonHandler {
+	if err := checkValidateTags(reflect.TypeOf((*In)(nil)).Elem()); err != nil {
+		panic(err)
+	}
+	return NewJsonHandler(func(req *http.Request) interface{} {
+		in := new(In)
+		if werr := b.Bind(req, in); werr != nil {
+			return rejectedRequest{werr}
+		}
+		return handler(req, in)
+	})
+}
+
+///////////////////////////////////////////////////////////
+
+// Validates `v` (a struct or pointer to one) against its `validate` struct tags, e.g.
+//
+//	Name string `json:"name" validate:"required,max=64,pattern=^[a-z ]+$"`
+//
+// Rules are `required` (not the zero value), `min=N` and `max=N` (bounds on numbers, and on the length of strings,
+// slices and maps) and `pattern=RE` (strings; must come last as it may contain commas). Nested structs and slices of
+// them are validated too. Fields are named as in JSON. Returns nil if `v` is valid.
+func Validate(v interface{}) FieldErrors {
+	fe := make(FieldErrors)
+	validateValue(reflect.ValueOf(v), "", fe)
+	if len(fe) == 0 {
+		return nil
+	}
+	return fe
+}
+
+func validateValue(v reflect.Value, path string, fe FieldErrors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			name, ok := jsonFieldName(f)
+			if !ok {
+				continue
+			}
+
+			fv := v.Field(i)
+			fpath := path
+			if !f.Anonymous {
+				fpath = joinFieldPath(path, name)
+			}
+			if tag := f.Tag.Get("validate"); tag != "" {
+				validateField(fv, fpath, tag, fe)
+			}
+			validateValue(fv, fpath, fe)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fe)
+		}
+	}
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" {
+		return f.Name, true
+	}
+	return tag, true
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+type validateRule struct {
+	name, arg string
+}
+
+// Splits a `validate` tag into its rules:
+func parseValidateTag(tag string) []validateRule {
+	var rules []validateRule
+	for tag != "" {
+		rule := tag
+		if strings.HasPrefix(rule, "pattern=") {
+			tag = ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+
+		r := validateRule{name: rule}
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			r.name, r.arg = rule[:i], rule[i+1:]
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func validateField(v reflect.Value, path, tag string, fe FieldErrors) {
+	for _, r := range parseValidateTag(tag) {
+		if r.name == "required" {
+			if v.IsZero() {
+				fe.Add(path, "is required")
+				// Other rules don't apply to a missing value:
+				return
+			}
+			continue
+		}
+
+		// Optional values which are absent pass all other rules:
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+
+		if msg := checkRule(v, r.name, r.arg); msg != "" {
+			fe.Add(path, msg)
+		}
+	}
+}
+
+// Checks the `validate` tags of type `t` and the types it contains, so mistakes show up before any request does.
+// Rules on interface fields can only be checked against their values:
+func checkValidateTags(t reflect.Type) error {
+	return checkTypeTags(t, make(map[reflect.Type]bool))
+}
+
+func checkTypeTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if _, ok := jsonFieldName(f); !ok {
+			continue
+		}
+		for _, r := range parseValidateTag(f.Tag.Get("validate")) {
+			if err := checkRuleType(f.Type, r.name, r.arg); err != nil {
+				return fmt.Errorf("validate: %s.%s: %w", t, f.Name, err)
+			}
+		}
+		if err := checkTypeTags(f.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the rule exists and applies to values of type `t`:
+func checkRuleType(t reflect.Type, name, arg string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch name {
+	case "required":
+		return nil
+	case "min", "max":
+		if _, err := strconv.ParseFloat(arg, 64); err != nil {
+			return fmt.Errorf("invalid %s bound '%s'", name, arg)
+		}
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Interface,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+			return nil
+		}
+		return fmt.Errorf("%s does not apply to %s", name, t)
+	case "pattern":
+		if t.Kind() != reflect.String && t.Kind() != reflect.Interface {
+			return fmt.Errorf("pattern does not apply to %s", t)
+		}
+		_, err := regexp.Compile(arg)
+		return err
+	default:
+		return fmt.Errorf("unknown rule '%s'", name)
+	}
+}
+
+// Returns the error message for a value which fails a rule, or "":
+func checkRule(v reflect.Value, name, arg string) string {
+	switch name {
+	case "min", "max":
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Errorf("validate: invalid %s bound '%s'", name, arg))
+		}
+
+		n, isLen := 0.0, false
+		switch v.Kind() {
+		case reflect.String:
+			n, isLen = float64(utf8.RuneCountInString(v.String())), true
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n, isLen = float64(v.Len()), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			panic(fmt.Errorf("validate: %s does not apply to %s", name, v.Type()))
+		}
+
+		if name == "min" && n < bound {
+			if isLen {
+				return fmt.Sprintf("must have a length of at least %s", arg)
+			}
+			return fmt.Sprintf("must be at least %s", arg)
+		}
+		if name == "max" && n > bound {
+			if isLen {
+				return fmt.Sprintf("must have a length of at most %s", arg)
+			}
+			return fmt.Sprintf("must be at most %s", arg)
+		}
+	case "pattern":
+		if v.Kind() != reflect.String {
+			panic(fmt.Errorf("validate: pattern does not apply to %s", v.Type()))
+		}
+		if !compilePattern(arg).MatchString(v.String()) {
+			return fmt.Sprintf("must match the pattern %s", arg)
+		}
+	default:
+		panic(fmt.Errorf("validate: unknown rule '%s'", name))
+	}
+	return ""
+}
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
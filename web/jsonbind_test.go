@@ -0,0 +1,201 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"pattern=^[0-9]{5}$"`
+}
+
+type testEmbedded struct {
+	Tag string `json:"tag" validate:"max=3"`
+}
+
+type testUser struct {
+	testEmbedded
+	Name      string        `json:"name" validate:"required,min=2,max=5"`
+	Age       int           `json:"age" validate:"min=18,max=130"`
+	Score     float64       `json:"score" validate:"max=1.5"`
+	Nick      *string       `json:"nick,omitempty" validate:"min=3"`
+	Code      string        `json:"code" validate:"pattern=^[a-z]{1,2}(,[a-z]{1,2})*$"`
+	Roles     []string      `json:"roles" validate:"min=1"`
+	Home      testAddress   `json:"home"`
+	Addresses []testAddress `json:"addresses"`
+	Ignored   string        `json:"-" validate:"required"`
+	NoTag     string        `validate:"max=1"`
+}
+
+func strPtr(s string) *string { return &s }
+
+func validUser() testUser {
+	return testUser{
+		Name:  "Jo",
+		Age:   30,
+		Roles: []string{"admin"},
+		Code:  "ab,c",
+		Home:  testAddress{City: "Oslo", Zip: "01234"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(u *testUser)
+		want   FieldErrors
+	}{
+		{"valid", func(u *testUser) {}, nil},
+		{"required", func(u *testUser) { u.Name = "" }, FieldErrors{"name": {"is required"}}},
+		{"length counts runes", func(u *testUser) { u.Name = "ÅÄÖÜß" }, nil},
+		{"too short", func(u *testUser) { u.Name = "J" }, FieldErrors{"name": {"must have a length of at least 2"}}},
+		{"too long", func(u *testUser) { u.Name = "Johnny" }, FieldErrors{"name": {"must have a length of at most 5"}}},
+		{"number min", func(u *testUser) { u.Age = 17 }, FieldErrors{"age": {"must be at least 18"}}},
+		{"number max", func(u *testUser) { u.Age = 131 }, FieldErrors{"age": {"must be at most 130"}}},
+		{"float max", func(u *testUser) { u.Score = 1.6 }, FieldErrors{"score": {"must be at most 1.5"}}},
+		{"slice length", func(u *testUser) { u.Roles = nil }, FieldErrors{"roles": {"must have a length of at least 1"}}},
+		{"absent pointer", func(u *testUser) { u.Nick = nil }, nil},
+		{"present pointer", func(u *testUser) { u.Nick = strPtr("ab") }, FieldErrors{"nick": {"must have a length of at least 3"}}},
+		{"pattern with commas", func(u *testUser) { u.Code = "ab,cde" }, FieldErrors{"code": {"must match the pattern ^[a-z]{1,2}(,[a-z]{1,2})*$"}}},
+		{"nested", func(u *testUser) { u.Home.City = "" }, FieldErrors{"home.city": {"is required"}}},
+		{"slice elements", func(u *testUser) {
+			u.Addresses = []testAddress{{City: "Rome"}, {City: "", Zip: "x"}}
+		}, FieldErrors{
+			"addresses[0].zip":  {"must match the pattern ^[0-9]{5}$"},
+			"addresses[1].city": {"is required"},
+			"addresses[1].zip":  {"must match the pattern ^[0-9]{5}$"},
+		}},
+		{"embedded", func(u *testUser) { u.Tag = "long" }, FieldErrors{"tag": {"must have a length of at most 3"}}},
+		{"Go name without json tag", func(u *testUser) { u.NoTag = "ab" }, FieldErrors{"NoTag": {"must have a length of at most 1"}}},
+	}
+	for _, tt := range tests {
+		u := validUser()
+		tt.modify(&u)
+		if got := Validate(&u); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Several failures are all reported:
+	u := validUser()
+	u.Name, u.Age = "", 1
+	if got := Validate(u); len(got) != 2 {
+		t.Errorf("got %v, want errors for name and age", got)
+	}
+}
+
+func TestValidateBadRules(t *testing.T) {
+	for _, v := range []interface{}{
+		struct {
+			A string `validate:"bogus"`
+		}{"x"},
+		struct {
+			A bool `validate:"min=1"`
+		}{true},
+		struct {
+			A int `validate:"max=many"`
+		}{1},
+		struct {
+			A int `validate:"pattern=^1$"`
+		}{1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T: no panic for a bad rule", v)
+				}
+			}()
+			Validate(v)
+		}()
+	}
+}
+
+func TestTypedJsonHandlerRejectsWithoutStack(t *testing.T) {
+	var logged bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logged)
+
+	called := false
+	h := NewTypedJsonHandler(func(req *http.Request, u *testUser) interface{} {
+		called = true
+		return u
+	})
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"","age":30,"roles":["a"],"home":{"city":"x"}}`, http.StatusUnprocessableEntity},
+		{`{"name":`, http.StatusBadRequest},
+		{`{"nope":1}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		logged.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.status)
+		}
+		if strings.Contains(logged.String(), "STACK") || strings.Contains(logged.String(), "ERROR") {
+			t.Errorf("%s: logged %q", tt.body, logged.String())
+		}
+	}
+	if called {
+		t.Error("handler called with an invalid body")
+	}
+}
+
+type badRuleInput struct {
+	Items []struct {
+		Done bool `json:"done" validate:"min=1"`
+	} `json:"items"`
+}
+
+func TestTypedJsonHandlerChecksTags(t *testing.T) {
+	func() {
+		defer func() {
+			p := recover()
+			if p == nil || !strings.Contains(fmt.Sprint(p), "min does not apply to bool") {
+				t.Errorf("recovered %v", p)
+			}
+		}()
+		NewTypedJsonHandler(func(req *http.Request, in *badRuleInput) interface{} { return nil })
+	}()
+
+	// Valid tags, including recursive types, pass:
+	type node struct {
+		Name     string  `json:"name" validate:"required,pattern=^[a-z,]+$"`
+		Children []*node `json:"children" validate:"max=3"`
+	}
+	NewTypedJsonHandler(func(req *http.Request, in *node) interface{} { return nil })
+	NewTypedJsonHandler(func(req *http.Request, in *testUser) interface{} { return nil })
+
+	for _, v := range []interface{}{
+		struct {
+			A string `validate:"bogus"`
+		}{},
+		struct {
+			A int `validate:"max=many"`
+		}{},
+		struct {
+			A int `validate:"pattern=^1$"`
+		}{},
+		struct {
+			A *string `validate:"pattern=("`
+		}{},
+	} {
+		if err := checkValidateTags(reflect.TypeOf(v)); err == nil {
+			t.Errorf("%T: no error", v)
+		}
+	}
+}
@@ -11,6 +11,7 @@ type JsonHandlerFunc func(*http.Request) interface{}
 
 type JsonHandler struct {
 	handler JsonHandlerFunc
+	mode    JsonResponseMode
 }
 
 func NewJsonHandler(handler JsonHandlerFunc) JsonHandler {
@@ -20,9 +21,6 @@ func NewJsonHandler(handler JsonHandlerFunc) JsonHandler {
 func (h JsonHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
 	var result interface{}
 
-	// We're guaranteed that we want to return a JSON result:
-	rsp.Header().Add("Content-Type", "application/json; charset=utf-8")
-
 	// Try to run the handler logic and catch any panics:
 	pnk, stackTrace := base.Try(func() {
 		result = h.handler(req)
@@ -30,33 +28,33 @@ func (h JsonHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
 
 	// Handle the panic:
 	if pnk != nil {
-		statusCode, userMessage, logError := getErrorDetails(pnk, stackTrace)
-
-		// Log the private error details:
-		log.Printf("ERROR: %s\n", logError)
+		writeJsonError(rsp, pnk, stackTrace)
+		return
+	}
+	// A request the handler rejected without panicking:
+	if rejected, ok := result.(rejectedRequest); ok {
+		writeJsonError(rsp, rejected.httpError(), "")
+		return
+	}
 
-		// Error response:
-		rsp.WriteHeader(statusCode)
-		bytes, _ := json.Marshal(struct {
-			Success bool   `json:"success"`
-			Message string `json:"message"`
-		}{
-			Success: false,
-			Message: userMessage,
-		})
-		rsp.Write(bytes)
+	switch h.mode {
+	case JsonNDJSON, JsonArray:
+		h.stream(rsp, req, result)
 		return
+	case JsonEnvelope:
+		result = struct {
+			StatusCode int         `json:"statusCode"`
+			Result     interface{} `json:"result"`
+		}{
+			StatusCode: http.StatusOK,
+			Result:     result,
+		}
 	}
 
-	// Marshal the successful response to JSON:
-	//bytes, err := json.Marshal(struct {
-	//	Success bool        `json:"success"`
-	//	Result  interface{} `json:"result"`
-	//}{
-	//	Success: false,
-	//	Result:  result,
-	//})
+	// We're guaranteed that we want to return a JSON result:
+	rsp.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	// Marshal the successful response to JSON:
 	bytes, err := json.Marshal(result)
 	if err != nil {
 		log.Printf("There was an error attempting to marshal the response object to JSON; %s\n", err.Error())
@@ -72,3 +70,32 @@ func (h JsonHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
 	rsp.Write(bytes)
 	return
 }
+
+func writeJsonError(rsp http.ResponseWriter, pnk interface{}, stackTrace string) {
+	statusCode, userMessage, logError := getErrorDetails(pnk, stackTrace)
+
+	// Log the private error details; a client's mistake doesn't need a stack trace:
+	if herr, ok := asHttpError(pnk); ok && statusCode < http.StatusInternalServerError {
+		log.Printf("%d: %s\n", statusCode, herr.Error())
+	} else {
+		log.Printf("ERROR: %s\n", logError)
+	}
+
+	// Problem details response:
+	if p := getProblem(pnk, userMessage); p != nil {
+		WriteProblem(rsp, statusCode, p)
+		return
+	}
+
+	// Error response:
+	rsp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rsp.WriteHeader(statusCode)
+	bytes, _ := json.Marshal(struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: false,
+		Message: userMessage,
+	})
+	rsp.Write(bytes)
+}
@@ -0,0 +1,331 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/JamesDunne/go-util/base"
+)
+
+type JsonResponseMode int
+
+const (
+	// The result is marshaled as is.
+	JsonRaw JsonResponseMode = iota
+	// The result is wrapped as `{"statusCode":200,"result":...}`, as `JsonSuccess` does.
+	JsonEnvelope
+	// The result is streamed as newline-delimited JSON (`application/x-ndjson`), one value per line.
+	JsonNDJSON
+	// The result is streamed as the elements of a JSON array.
+	JsonArray
+)
+
+// Trailer set when an NDJSON response fails after its headers were sent.
+const StreamErrorTrailer = "X-Stream-Error"
+
+// Returns a copy of the handler which responds in `mode`.
+//
+// In the streaming modes the handler may return a `JsonStream`, rows from `sqlx` (see `JsonRows`), a channel, an
+// iterator function like `func(yield func(T) bool)` or `func(yield func(T, error) bool)`, or a slice; any other value
+// is written as a single element. Streaming stops when the client goes away, so channel producers should also watch
+// `req.Context()`. Errors before the first element get a normal error response. Later errors end NDJSON with a
+// `{"success":false,"message":...}` line and set the `X-Stream-Error` trailer, while arrays are left unterminated and
+// the connection aborted, so clients can't mistake a partial result for a complete one.
+func (h JsonHandler) WithMode(mode JsonResponseMode) JsonHandler {
+	h.mode = mode
+	return h
+}
+
+// A pull-style source of values to stream; `Next` returns `io.EOF` after the last value. Streams which implement
+// `io.Closer` are closed once streaming stops.
+type JsonStream interface {
+	Next() (interface{}, error)
+}
+
+// Satisfied by `*sqlx.Rows`.
+type SqlxRows interface {
+	Next() bool
+	Err() error
+	Close() error
+	MapScan(dest map[string]interface{}) error
+	StructScan(dest interface{}) error
+}
+
+type rowsStream struct {
+	rows   SqlxRows
+	newRow func() interface{}
+}
+
+// Streams `rows`, scanning each into a fresh value from `newRow`, e.g. `func() interface{} { return new(User) }`.
+// With a nil `newRow` each row is scanned into a map keyed by column name.
+func JsonRows(rows SqlxRows, newRow func() interface{}) JsonStream {
+	return rowsStream{rows: rows, newRow: newRow}
+}
+
+func (s rowsStream) Next() (interface{}, error) {
+	if !s.rows.Next() {
+		if err := s.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	if s.newRow != nil {
+		row := s.newRow()
+		if err := s.rows.StructScan(row); err != nil {
+			return nil, err
+		}
+		return row, nil
+	}
+
+	row := make(map[string]interface{})
+	if err := s.rows.MapScan(row); err != nil {
+		return nil, err
+	}
+	// Drivers return text columns as []byte, which would marshal as base64:
+	for k, v := range row {
+		if b, ok := v.([]byte); ok {
+			row[k] = string(b)
+		}
+	}
+	return row, nil
+}
+
+func (s rowsStream) Close() error {
+	return s.rows.Close()
+}
+
+// Calls `emit` with each value of `result` until it runs out, `emit` fails, or `ctx` is done:
+func forEachJson(ctx context.Context, result interface{}, emit func(interface{}) error) error {
+	switch s := result.(type) {
+	case nil:
+		return nil
+	case SqlxRows:
+		result = JsonRows(s, nil)
+	}
+	if c, ok := result.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if s, ok := result.(JsonStream); ok {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v, err := s.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = emit(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	v := reflect.ValueOf(result)
+	switch v.Kind() {
+	case reflect.Chan:
+		if v.IsNil() {
+			return nil
+		}
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		}
+		for {
+			i, x, ok := reflect.Select(cases)
+			if i == 1 {
+				return ctx.Err()
+			}
+			if !ok {
+				return nil
+			}
+			// A channel of interface{} or error may deliver an error to fail the stream:
+			if err, isErr := x.Interface().(error); isErr {
+				return err
+			}
+			if err := emit(x.Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+		if !isIterator(v.Type()) {
+			break
+		}
+
+		var err error
+		yield := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+			if err = ctx.Err(); err == nil {
+				if len(args) == 2 && !args[1].IsNil() {
+					err = args[1].Interface().(error)
+				} else {
+					err = emit(args[0].Interface())
+				}
+			}
+			return []reflect.Value{reflect.ValueOf(err == nil)}
+		})
+		v.Call([]reflect.Value{yield})
+		return err
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := emit(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return emit(result)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Matches `func(yield func(T) bool)` and `func(yield func(T, error) bool)`:
+func isIterator(t reflect.Type) bool {
+	if t.NumIn() != 1 || t.NumOut() != 0 {
+		return false
+	}
+	y := t.In(0)
+	if y.Kind() != reflect.Func || y.NumOut() != 1 || y.Out(0).Kind() != reflect.Bool {
+		return false
+	}
+	return y.NumIn() == 1 || (y.NumIn() == 2 && y.In(1) == errorType)
+}
+
+type jsonStreamWriter struct {
+	rsp     http.ResponseWriter
+	mode    JsonResponseMode
+	buf     *bufio.Writer
+	started bool
+	count   int
+}
+
+func (s *jsonStreamWriter) start() {
+	s.started = true
+
+	contentType := "application/json; charset=utf-8"
+	if s.mode == JsonNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	s.rsp.Header().Set("Content-Type", contentType)
+	// Arrays end with an aborted connection instead, which never gets to send trailers:
+	if s.mode == JsonNDJSON {
+		s.rsp.Header().Set("Trailer", StreamErrorTrailer)
+	}
+	s.rsp.WriteHeader(http.StatusOK)
+
+	if s.mode == JsonArray {
+		s.buf.WriteByte('[')
+	}
+}
+
+func (s *jsonStreamWriter) emit(v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if !s.started {
+		s.start()
+	}
+	if s.mode == JsonArray && s.count > 0 {
+		s.buf.WriteByte(',')
+	}
+	s.count++
+	if _, err = s.buf.Write(j); err != nil {
+		return err
+	}
+
+	if s.mode == JsonNDJSON {
+		s.buf.WriteByte('\n')
+		return s.flush()
+	}
+	// Array elements are sent as the buffer fills.
+	return nil
+}
+
+func (s *jsonStreamWriter) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.rsp.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *jsonStreamWriter) finish() {
+	if !s.started {
+		s.start()
+	}
+	if s.mode == JsonArray {
+		s.buf.WriteByte(']')
+	}
+	s.flush()
+}
+
+// Streams `result` in the handler's mode:
+func (h JsonHandler) stream(rsp http.ResponseWriter, req *http.Request, result interface{}) {
+	s := &jsonStreamWriter{rsp: rsp, mode: h.mode, buf: bufio.NewWriter(rsp)}
+
+	var err error
+	pnk, stackTrace := base.Try(func() {
+		err = forEachJson(req.Context(), result, s.emit)
+	})
+	if pnk == nil && err == nil {
+		s.finish()
+		return
+	}
+	if pnk == nil {
+		pnk = err
+	}
+
+	// Nothing sent yet, so respond normally:
+	if !s.started {
+		writeJsonError(rsp, pnk, stackTrace)
+		return
+	}
+
+	statusCode, userMessage, logError := getErrorDetails(pnk, stackTrace)
+	log.Printf("ERROR: %d after streaming %d elements: %s\n", statusCode, s.count, logError)
+	if req.Context().Err() != nil {
+		// The client is gone:
+		return
+	}
+
+	if s.mode == JsonNDJSON {
+		rsp.Header().Set(StreamErrorTrailer, userMessage)
+		j, _ := json.Marshal(struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}{
+			Success: false,
+			Message: userMessage,
+		})
+		s.buf.Write(j)
+		s.buf.WriteByte('\n')
+		s.flush()
+		return
+	}
+
+	// Leave the array unterminated and abort the connection:
+	s.flush()
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Yields 1 and 2, then fails:
+func failingStream(req *http.Request) interface{} {
+	return func(yield func(int, error) bool) {
+		for i := 1; i <= 2; i++ {
+			if !yield(i, nil) {
+				return
+			}
+		}
+		yield(0, NewHttpError(http.StatusBadGateway, "upstream failed", errors.New("upstream: timeout")))
+	}
+}
+
+func TestJsonStreamNDJSONErrorTrailer(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	w := httptest.NewRecorder()
+	NewJsonHandler(failingStream).WithMode(JsonNDJSON).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	rsp := w.Result()
+	if got := rsp.Header.Get("Trailer"); got != StreamErrorTrailer {
+		t.Errorf("Trailer: %q", got)
+	}
+	if got := rsp.Trailer.Get(StreamErrorTrailer); got != "upstream failed" {
+		t.Errorf("%s: %q", StreamErrorTrailer, got)
+	}
+	if want := "1\n2\n{\"success\":false,\"message\":\"upstream failed\"}\n"; w.Body.String() != want {
+		t.Errorf("body %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestJsonStreamArrayAborts(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+			}
+		}()
+		NewJsonHandler(failingStream).WithMode(JsonArray).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}()
+
+	if got := w.Header().Get("Trailer"); got != "" {
+		t.Errorf("Trailer announced for an array: %q", got)
+	}
+	if w.Body.String() != "[1,2" {
+		t.Errorf("body %q", w.Body.String())
+	}
+}
+
+func TestJsonStreamArray(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewJsonHandler(func(req *http.Request) interface{} {
+		return []int{1, 2, 3}
+	}).WithMode(JsonArray).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Body.String() != "[1,2,3]" {
+		t.Errorf("body %q", w.Body.String())
+	}
+	if got := w.Header().Get("Trailer"); got != "" {
+		t.Errorf("Trailer: %q", got)
+	}
+}
+
+func TestJsonEnvelopeMatchesJsonSuccess(t *testing.T) {
+	result := map[string]int{"n": 1}
+
+	w := httptest.NewRecorder()
+	NewJsonHandler(func(req *http.Request) interface{} {
+		return result
+	}).WithMode(JsonEnvelope).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	want := httptest.NewRecorder()
+	JsonSuccess(want, result)
+	if w.Body.String() != want.Body.String() {
+		t.Errorf("envelope %q, JsonSuccess %q", w.Body.String(), want.Body.String())
+	}
+}
@@ -0,0 +1,495 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JamesDunne/go-util/base"
+)
+
+// Wraps an `ErrorHandler` to add behavior before or after it, seeing the `*Error` it returns.
+type Middleware func(ErrorHandler) ErrorHandler
+
+// An ordered list of middleware; the first is outermost.
+type Chain []Middleware
+
+func NewChain(mw ...Middleware) Chain {
+	return append(Chain(nil), mw...)
+}
+
+// Returns a new chain with `mw` added after the existing middleware.
+func (c Chain) Append(mw ...Middleware) Chain {
+	return append(append(Chain(nil), c...), mw...)
+}
+
+// Wraps `h` in all middleware of the chain.
+func (c Chain) Then(h ErrorHandler) ErrorHandler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+func (c Chain) ThenFunc(f ErrorHandlerFunc) ErrorHandler {
+	return c.Then(f)
+}
+
+///////////////////////////////////////////////////////////
+
+// Header used by `RequestID` to accept and return request IDs.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// Returns the request ID assigned by the `RequestID` middleware, or "".
+func RequestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Assigns each request an ID, reusing a well-formed incoming `X-Request-Id`, and echoes it in the response.
+func RequestID() Middleware {
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" || len(id) > 128 || strings.ContainsAny(id, " \t\r\n") {
+				id = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+			return h.ServeHTTP(w, r)
+		})
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+// Records the status and size of a response:
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Logs every request with its status, response size and duration, plus the error message for failures.
+// Logs to the standard logger if `out` is nil.
+func AccessLog(out *log.Logger) Middleware {
+	logf := log.Printf
+	if out != nil {
+		logf = out.Printf
+	}
+
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			werr := h.ServeHTTP(sw, r)
+
+			status := sw.status
+			msg := ""
+			if werr != nil {
+				status = werr.StatusCode
+				if werr.Error != nil {
+					msg = " ERROR " + werr.Error.Error()
+				}
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			id := RequestIDFrom(r)
+			if id != "" {
+				id = " [" + id + "]"
+			}
+			logf("%3d %s %s %dB %s%s%s\n", status, r.Method, r.URL, sw.bytes, time.Since(start), id, msg)
+			return werr
+		})
+	}
+}
+
+// Middleware form of `Log`.
+func ErrorLog(logfunc ErrorLogFunc) Middleware {
+	return func(h ErrorHandler) ErrorHandler {
+		return Log(logfunc, h)
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+// Recovers from panics in the handler, logging the panic and stack trace and returning a 500 error (or the status
+// of a panicked `HttpError`). `http.ErrAbortHandler` is panicked again, so the server aborts the response as intended.
+func Recover() Middleware {
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) (werr *Error) {
+			pnk, stackTrace := base.Try(func() {
+				werr = h.ServeHTTP(w, r)
+			})
+			if pnk == nil {
+				return werr
+			}
+			if pnk == http.ErrAbortHandler {
+				panic(pnk)
+			}
+
+			statusCode, userMessage, logError := getErrorDetails(pnk, stackTrace)
+			log.Printf("ERROR: %s\n", logError)
+			return AsError(errors.New(userMessage), statusCode)
+		})
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+// Buffers a response so it can be discarded if the handler times out:
+type timeoutWriter struct {
+	lock     sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.status == 0 && !w.timedOut {
+		w.status = status
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// Cancels the request context after `d` and returns a 503 error if the handler has not finished by then.
+// The response is buffered until the handler returns, so it is not suitable for streaming handlers.
+func Timeout(d time.Duration) Middleware {
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan *Error, 1)
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				done <- h.ServeHTTP(tw, r)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case werr := <-done:
+				tw.lock.Lock()
+				defer tw.lock.Unlock()
+
+				dst := w.Header()
+				for k, vv := range tw.header {
+					dst[k] = vv
+				}
+				if tw.status != 0 {
+					w.WriteHeader(tw.status)
+				}
+				w.Write(tw.buf.Bytes())
+				return werr
+			case <-ctx.Done():
+				tw.lock.Lock()
+				tw.timedOut = true
+				tw.lock.Unlock()
+				return AsError(fmt.Errorf("handler did not finish within %s", d), http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+type CORSOptions struct {
+	// Origins allowed to make requests; "*" allows any.
+	AllowedOrigins []string
+	// Defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// Allows cookies and other credentials; the request origin is echoed instead of "*".
+	AllowCredentials bool
+	// How long browsers may cache a preflight response; zero to omit.
+	MaxAge time.Duration
+}
+
+func (o *CORSOptions) allowsOrigin(origin string) bool {
+	for _, a := range o.AllowedOrigins {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds CORS headers for allowed origins and answers preflight requests.
+func CORS(o CORSOptions) Middleware {
+	if len(o.AllowedMethods) == 0 {
+		o.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return h.ServeHTTP(w, r)
+			}
+
+			hdr := w.Header()
+			hdr.Add("Vary", "Origin")
+			if !o.allowsOrigin(origin) {
+				return h.ServeHTTP(w, r)
+			}
+
+			if o.AllowCredentials || !o.allowsOrigin("*") {
+				hdr.Set("Access-Control-Allow-Origin", origin)
+			} else {
+				hdr.Set("Access-Control-Allow-Origin", "*")
+			}
+			if o.AllowCredentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight:
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				hdr.Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+				if len(o.AllowedHeaders) > 0 {
+					hdr.Set("Access-Control-Allow-Headers", strings.Join(o.AllowedHeaders, ", "))
+				} else if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+					hdr.Set("Access-Control-Allow-Headers", req)
+				}
+				if o.MaxAge > 0 {
+					hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge/time.Second)))
+				}
+				return NewError(nil, http.StatusNoContent, Empty)
+			}
+
+			if len(o.ExposedHeaders) > 0 {
+				hdr.Set("Access-Control-Expose-Headers", strings.Join(o.ExposedHeaders, ", "))
+			}
+			return h.ServeHTTP(w, r)
+		})
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+// Compresses the response body once the handler starts writing it. The status is held back until then, so the
+// content type can be sniffed from the uncompressed body:
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.status != 0 {
+		return
+	}
+	// Informational responses don't end the headers:
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+// Sends the held back status, compressing the body if there is one to compress:
+func (w *gzipWriter) writeHeader(compress bool) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	hdr := w.Header()
+	if compress && hdr.Get("Content-Encoding") == "" && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		// Sniff the content type before it is compressed:
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.writeHeader(true)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) Flush() {
+	// More is presumably on the way, e.g. for a stream:
+	w.writeHeader(true)
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Sends a status the handler set without writing a body, and ends the compressed stream:
+func (w *gzipWriter) close() {
+	if w.status != 0 {
+		w.writeHeader(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// Whether an `Accept-Encoding` header allows gzip, going by its quality values; "gzip;q=0" refuses it and "*"
+// covers it when it isn't listed:
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if len(p) > 2 && strings.EqualFold(p[:2], "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(p[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// Gzips responses for clients which accept it. Error responses written after the handler returns are not
+// compressed.
+func Gzip() Middleware {
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Method == http.MethodHead {
+				return h.ServeHTTP(w, r)
+			}
+
+			gw := &gzipWriter{ResponseWriter: w}
+			werr := h.ServeHTTP(gw, r)
+			gw.close()
+			return werr
+		})
+	}
+}
+
+///////////////////////////////////////////////////////////
+
+// Headers set by `SecurityHeaders(nil)`.
+var DefaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "DENY",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+}
+
+// Sets `headers` (or `DefaultSecurityHeaders` if nil) on every response, plus `Strict-Transport-Security` for TLS
+// requests.
+func SecurityHeaders(headers map[string]string) Middleware {
+	if headers == nil {
+		headers = DefaultSecurityHeaders
+	}
+
+	return func(h ErrorHandler) ErrorHandler {
+		return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+			hdr := w.Header()
+			for k, v := range headers {
+				hdr.Set(k, v)
+			}
+			if r.TLS != nil && hdr.Get("Strict-Transport-Security") == "" {
+				hdr.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			}
+			return h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5, br", true},
+		{"GZIP; Q=1", true},
+		{"x-gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"identity, deflate", false},
+		{"gzipped", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func serveGzip(h ErrorHandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	ReportErrors(Gzip()(h)).ServeHTTP(w, r)
+	return w
+}
+
+func gunzip(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding: %q", ce)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGzipSniffsAfterWriteHeader(t *testing.T) {
+	const page = "<!DOCTYPE html><html><body>hello</body></html>"
+	w := serveGzip(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(page))
+		return nil
+	}, "gzip")
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type: %q", ct)
+	}
+	if body := gunzip(t, w); body != page {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestGzipStatusWithoutBody(t *testing.T) {
+	w := serveGzip(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}, "gzip")
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status %d", w.Code)
+	}
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding %q for an empty body", ce)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body %q", w.Body.String())
+	}
+}
+
+func TestGzipRefused(t *testing.T) {
+	w := serveGzip(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.Write([]byte("plain"))
+		return nil
+	}, "gzip;q=0, identity")
+
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding: %q", ce)
+	}
+	if w.Body.String() != "plain" {
+		t.Errorf("body %q", w.Body.String())
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary: %q", vary)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	w := httptest.NewRecorder()
+	ReportErrors(Recover()(ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		panic(NewHttpError(http.StatusConflict, "taken", nil))
+	}))).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status %d", w.Code)
+	}
+}
+
+func TestRecoverAbort(t *testing.T) {
+	w := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+		if w.Body.String() != "partial" {
+			t.Errorf("body %q", w.Body.String())
+		}
+	}()
+	ReportErrors(Recover()(ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	}))).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+}
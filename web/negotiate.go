@@ -0,0 +1,147 @@
+package web
+
+import (
+	"encoding/json"
+	"html"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Writes an error response of a particular `ResponseKind`. `req` is nil when called from `Error.Respond`.
+type ErrorRenderer func(rsp http.ResponseWriter, req *http.Request, e *Error)
+
+var (
+	renderersLock sync.RWMutex
+	renderers     = map[ResponseKind]ErrorRenderer{
+		HTML:    renderHTML,
+		JSON:    renderJSON,
+		Text:    renderText,
+		Problem: renderProblem,
+		Empty:   renderEmpty,
+	}
+)
+
+// Replaces the renderer for errors of `kind`, e.g. to render HTML errors through the app's own templates.
+func RegisterErrorRenderer(kind ResponseKind, r ErrorRenderer) {
+	renderersLock.Lock()
+	defer renderersLock.Unlock()
+
+	renderers[kind] = r
+}
+
+func errorRenderer(kind ResponseKind) ErrorRenderer {
+	renderersLock.RLock()
+	defer renderersLock.RUnlock()
+
+	return renderers[kind]
+}
+
+func renderHTML(rsp http.ResponseWriter, req *http.Request, e *Error) {
+	rsp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rsp.WriteHeader(e.StatusCode)
+	// Messages may echo user input:
+	rsp.Write([]byte(html.EscapeString(e.message())))
+}
+
+func renderJSON(rsp http.ResponseWriter, req *http.Request, e *Error) {
+	rsp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rsp.WriteHeader(e.StatusCode)
+	j, jerr := json.Marshal(&struct {
+		StatusCode int    `json:"statusCode"`
+		Error      string `json:"error"`
+	}{
+		StatusCode: e.StatusCode,
+		Error:      e.message(),
+	})
+	if jerr != nil {
+		panic(jerr)
+	}
+	rsp.Write(j)
+}
+
+func renderText(rsp http.ResponseWriter, req *http.Request, e *Error) {
+	rsp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rsp.Header().Set("X-Content-Type-Options", "nosniff")
+	rsp.WriteHeader(e.StatusCode)
+	rsp.Write([]byte(e.message() + "\n"))
+}
+
+func renderProblem(rsp http.ResponseWriter, req *http.Request, e *Error) {
+	WriteProblem(rsp, e.StatusCode, e.problemDetails())
+}
+
+func renderEmpty(rsp http.ResponseWriter, req *http.Request, e *Error) {
+	rsp.WriteHeader(e.StatusCode)
+}
+
+// Kind used for `Undetermined` errors when the `Accept` header is missing or allows anything.
+var DefaultResponseKind = Text
+
+// Media types considered when negotiating, in order of preference on ties:
+var negotiableKinds = []struct {
+	mediaType string
+	kind      ResponseKind
+}{
+	{"application/problem+json", Problem},
+	{"application/json", JSON},
+	{"text/html", HTML},
+	{"text/plain", Text},
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// Parses an `Accept` header into media ranges ordered by descending quality:
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mt, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// Picks the `ResponseKind` for an error response from the request's `Accept` header.
+func NegotiateResponseKind(req *http.Request) ResponseKind {
+	if req == nil {
+		return DefaultResponseKind
+	}
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return DefaultResponseKind
+	}
+
+	for _, r := range parseAccept(accept) {
+		if r.mediaType == "*/*" {
+			return DefaultResponseKind
+		}
+		for _, k := range negotiableKinds {
+			if r.mediaType == k.mediaType {
+				return k.kind
+			}
+			// e.g. text/*:
+			if strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(k.mediaType, strings.TrimSuffix(r.mediaType, "*")) {
+				return k.kind
+			}
+		}
+	}
+	return DefaultResponseKind
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiatedHTMLErrorIsEscaped(t *testing.T) {
+	h := ReportErrors(ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		return AsError(fmt.Errorf("no user named '%s'", r.URL.Query().Get("u")), http.StatusNotFound)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/?u=%3Cscript%3Ealert(1)%3C/script%3E", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type: %q", ct)
+	}
+	if body := w.Body.String(); body != "no user named &#39;&lt;script&gt;alert(1)&lt;/script&gt;&#39;" {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestNegotiateResponseKind(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   ResponseKind
+	}{
+		{"", DefaultResponseKind},
+		{"*/*", DefaultResponseKind},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", HTML},
+		{"application/json", JSON},
+		{"application/problem+json, application/json;q=0.9", Problem},
+		{"text/*", HTML},
+		{"text/html;q=0, text/plain", Text},
+		{"image/png", DefaultResponseKind},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := NegotiateResponseKind(r); got != tt.want {
+			t.Errorf("NegotiateResponseKind(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestRespondUndetermined(t *testing.T) {
+	w := httptest.NewRecorder()
+	if !AsError(fmt.Errorf("boom"), http.StatusInternalServerError).Respond(w) {
+		t.Fatal("nothing written")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d", w.Code)
+	}
+	if w.Body.String() != "boom\n" {
+		t.Errorf("body %q", w.Body.String())
+	}
+}
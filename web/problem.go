@@ -0,0 +1,142 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// RFC 7807 problem details, serialized as `application/problem+json`.
+type ProblemDetails struct {
+	// URI identifying the problem type; defaults to "about:blank".
+	Type string
+	// Short summary of the problem type; defaults to the status text.
+	Title string
+	// Filled in from the error's status code when serialized.
+	Status int
+	// Explanation specific to this occurrence.
+	Detail string
+	// URI identifying this occurrence.
+	Instance string
+	// Validation errors keyed by field name, serialized as the "errors" member.
+	Errors map[string][]string
+	// Additional members, e.g. a machine-readable "code".
+	Extensions map[string]interface{}
+}
+
+func NewProblem(typeURI, title, detail string) *ProblemDetails {
+	return &ProblemDetails{Type: typeURI, Title: title, Detail: detail}
+}
+
+// Adds a validation error for `field`.
+func (p *ProblemDetails) AddFieldError(field, message string) *ProblemDetails {
+	if p.Errors == nil {
+		p.Errors = make(map[string][]string)
+	}
+	p.Errors[field] = append(p.Errors[field], message)
+	return p
+}
+
+// Sets an extension member.
+func (p *ProblemDetails) With(key string, value interface{}) *ProblemDetails {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *ProblemDetails) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+6)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+
+	m["type"] = p.Type
+	if p.Type == "" {
+		m["type"] = "about:blank"
+	}
+	m["title"] = p.Title
+	if p.Title == "" {
+		m["title"] = http.StatusText(p.Status)
+	}
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	if len(p.Errors) > 0 {
+		m["errors"] = p.Errors
+	}
+	return json.Marshal(m)
+}
+
+// Writes `p` as an `application/problem+json` response with `statusCode`.
+func WriteProblem(rsp http.ResponseWriter, statusCode int, p *ProblemDetails) {
+	// Don't modify the caller's copy:
+	pd := *p
+	pd.Status = statusCode
+
+	j, jerr := json.Marshal(&pd)
+	if jerr != nil {
+		panic(jerr)
+	}
+
+	rsp.Header().Set("Content-Type", "application/problem+json")
+	rsp.WriteHeader(statusCode)
+	rsp.Write(j)
+}
+
+// Creates an error which responds with problem details.
+func AsErrorProblem(err error, statusCode int, p *ProblemDetails) *Error {
+	if err == nil && p == nil {
+		return nil
+	}
+	return &Error{
+		ResponseKind: Problem,
+		StatusCode:   statusCode,
+		Error:        err,
+		Problem:      p,
+	}
+}
+
+// Attaches problem details to the error; JSON clients then receive `application/problem+json`.
+func (e *Error) WithProblem(p *ProblemDetails) *Error {
+	if e == nil {
+		return nil
+	}
+	e.Problem = p
+	return e
+}
+
+// Adds a validation error for `field` to the error's problem details, creating them if needed.
+func (e *Error) WithFieldError(field, message string) *Error {
+	if e == nil {
+		return nil
+	}
+	if e.Problem == nil {
+		e.Problem = &ProblemDetails{}
+	}
+	e.Problem.AddFieldError(field, message)
+	return e
+}
+
+// Returns the problem details to serialize, defaulting the detail to the error message:
+func (e *Error) problemDetails() *ProblemDetails {
+	p := ProblemDetails{}
+	if e.Problem != nil {
+		p = *e.Problem
+	}
+	if p.Detail == "" && e.Error != nil {
+		p.Detail = e.Error.Error()
+	}
+	return &p
+}
+
+// Attaches problem details to the error; `HttpErrorHandler` and `JsonHandler` then respond with
+// `application/problem+json`.
+func (e HttpError) WithProblem(p *ProblemDetails) HttpError {
+	e.Problem = p
+	return e
+}
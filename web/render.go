@@ -0,0 +1,277 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Renders pages from a `TemplateSet`, composing each page with a layout and the shared partials.
+//
+// Templates are named by their path in the set's directory. Layouts define the page structure with named blocks,
+// which pages fill in:
+//
+//	layouts/base.html:  <html><title>{{block "title" .}}Site{{end}}</title>{{block "content" .}}{{end}}</html>
+//	users/show.html:    {{define "title"}}{{.User.Name}}{{end}}{{define "content"}}...{{end}}
+//
+// Pages are compiled separately, so every page can define the same blocks. Shared templates (by default those in
+// "layouts/" and "partials/") are available to every page, with `{{template "partials/nav.html" .}}` or
+// `{{partial "partials/nav.html" .}}` for names chosen at run time. Pages are rendered into a buffer first, so a
+// template error gets a clean 500 response rather than a half-written page.
+type Renderer struct {
+	Set *TemplateSet
+	// For the `url` template function.
+	Router *Router
+	// Layout to render pages in with `Render`; "" to render pages on their own.
+	DefaultLayout string
+	// Name prefixes of the templates available to every page.
+	SharedPrefixes []string
+	// Optional; provides data for every page rendered for a request, e.g. the signed-in user.
+	Data func(r *http.Request) map[string]interface{}
+
+	preParse func(*template.Template) *template.Template
+
+	lock  sync.Mutex
+	snap  *templateSnapshot
+	pages map[[2]string]*template.Template
+}
+
+// Creates a renderer for `set`, adding `TemplateFuncs` and the renderer's own functions to it. Create it before the
+// set is loaded, or the set is reloaded here.
+func NewRenderer(set *TemplateSet, router *Router) *Renderer {
+	rd := &Renderer{
+		Set:            set,
+		Router:         router,
+		DefaultLayout:  "layouts/base.html",
+		SharedPrefixes: []string{"layouts/", "partials/"},
+		preParse:       set.PreParse,
+	}
+
+	set.PreParse = rd.addFuncs
+	if set.Template() != nil {
+		set.Load()
+	}
+	return rd
+}
+
+func (rd *Renderer) addFuncs(t *template.Template) *template.Template {
+	t = t.Funcs(TemplateFuncs()).Funcs(template.FuncMap{
+		"url": rd.url,
+		// Replaced for each compiled page:
+		"partial": func(name string, data interface{}) (template.HTML, error) {
+			return "", errors.New("partial: not rendered by a Renderer")
+		},
+	})
+	if rd.preParse != nil {
+		t = rd.preParse(t)
+	}
+	return t
+}
+
+// Template function: builds the path for a named route from parameter name/value pairs:
+func (rd *Renderer) url(name string, pairs ...interface{}) (string, error) {
+	if rd.Router == nil {
+		return "", errors.New("url: the renderer has no router")
+	}
+	if len(pairs)%2 != 0 {
+		return "", errors.New("url: expected parameter name/value pairs")
+	}
+
+	params := make(RouteParams, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		params[fmt.Sprint(pairs[i])] = fmt.Sprint(pairs[i+1])
+	}
+	return rd.Router.URL(name, params)
+}
+
+func (rd *Renderer) shared(name string) bool {
+	for _, prefix := range rd.SharedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the compiled template for `page` and the name to execute:
+func (rd *Renderer) page(layout, page string) (*template.Template, string, error) {
+	snap := rd.Set.snapshot()
+	if snap == nil {
+		if err := rd.Set.Err(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("templates in '%s' are not loaded", rd.Set.Dir())
+	}
+
+	entry := layout
+	if entry == "" {
+		entry = page
+	}
+
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+
+	// Compiled pages are only good for the templates they were compiled from:
+	if rd.snap != snap {
+		rd.snap = snap
+		rd.pages = make(map[[2]string]*template.Template)
+	}
+	key := [2]string{layout, page}
+	if t, ok := rd.pages[key]; ok {
+		return t, entry, nil
+	}
+
+	t, err := rd.compile(snap, layout, page)
+	if err != nil {
+		return nil, "", err
+	}
+	rd.pages[key] = t
+	return t, entry, nil
+}
+
+func (rd *Renderer) compile(snap *templateSnapshot, layout, page string) (*template.Template, error) {
+	src, ok := snap.sources[page]
+	if !ok {
+		return nil, fmt.Errorf("no template named '%s'", page)
+	}
+	if layout != "" {
+		if _, ok := snap.sources[layout]; !ok {
+			return nil, fmt.Errorf("no layout named '%s'", layout)
+		}
+	}
+
+	t := rd.addFuncs(template.New(rd.Set.Name))
+	for _, name := range snap.names {
+		if name == page || !rd.shared(name) {
+			continue
+		}
+		if _, err := t.New(name).Parse(snap.sources[name]); err != nil {
+			return nil, err
+		}
+	}
+	// The page goes last so its blocks replace the layout's defaults:
+	if _, err := t.New(page).Parse(src); err != nil {
+		return nil, err
+	}
+
+	t.Funcs(template.FuncMap{
+		"partial": func(name string, data interface{}) (template.HTML, error) {
+			var b bytes.Buffer
+			if err := t.ExecuteTemplate(&b, name, data); err != nil {
+				return "", err
+			}
+			return template.HTML(b.String()), nil
+		},
+	})
+	return t, nil
+}
+
+type templateDataKey struct{}
+
+// Returns a request carrying `data` for every page rendered for it, on top of any added before; e.g. for middleware
+// to provide the signed-in user.
+func WithTemplateData(r *http.Request, data map[string]interface{}) *http.Request {
+	prev, _ := r.Context().Value(templateDataKey{}).(map[string]interface{})
+	merged := make(map[string]interface{}, len(prev)+len(data))
+	for k, v := range prev {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return r.WithContext(context.WithValue(r.Context(), templateDataKey{}, merged))
+}
+
+// Merges the data for a page; later sources win:
+//  1. "Request": the request
+//  2. the renderer's `Data` function
+//  3. data added with `WithTemplateData`
+//  4. `data` itself if it's a map, otherwise it's under "Data"
+func (rd *Renderer) viewData(r *http.Request, data interface{}) map[string]interface{} {
+	m := map[string]interface{}{"Request": r}
+	if rd.Data != nil {
+		for k, v := range rd.Data(r) {
+			m[k] = v
+		}
+	}
+	if reqData, ok := r.Context().Value(templateDataKey{}).(map[string]interface{}); ok {
+		for k, v := range reqData {
+			m[k] = v
+		}
+	}
+
+	switch d := data.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range d {
+			m[k] = v
+		}
+	default:
+		m["Data"] = d
+	}
+	return m
+}
+
+// Renders `page` in the default layout with `statusCode`.
+func (rd *Renderer) Render(w http.ResponseWriter, r *http.Request, statusCode int, page string, data interface{}) *Error {
+	return rd.RenderLayout(w, r, statusCode, rd.DefaultLayout, page, data)
+}
+
+// Renders `page` in `layout`, or on its own if `layout` is "". Nothing is written if rendering fails; the error is
+// returned as an HTML 500, with the details only in `Dev` mode. The `Dev` error overlay is only added to pages
+// rendered in a layout.
+func (rd *Renderer) RenderLayout(w http.ResponseWriter, r *http.Request, statusCode int, layout, page string, data interface{}) *Error {
+	t, entry, err := rd.page(layout, page)
+	if err != nil {
+		return rd.renderError(page, err)
+	}
+
+	var b bytes.Buffer
+	if err = t.ExecuteTemplate(&b, entry, rd.viewData(r, data)); err != nil {
+		return rd.renderError(page, err)
+	}
+	if layout != "" {
+		b.WriteString(string(rd.Set.ErrorOverlay()))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	w.Write(b.Bytes())
+	return nil
+}
+
+// Template errors name files and fields, so clients only see them in `Dev` mode:
+func (rd *Renderer) renderError(page string, err error) *Error {
+	err = fmt.Errorf("rendering %s: %w", page, err)
+	if rd.Set.Dev {
+		return AsErrorHTML(err, http.StatusInternalServerError)
+	}
+	log.Printf("ERROR: %s\n", err)
+	return NewError(nil, http.StatusInternalServerError, HTML)
+}
+
+// Renders a single template without a layout, e.g. a fragment for a partial page update.
+func (rd *Renderer) RenderPartial(w http.ResponseWriter, r *http.Request, statusCode int, name string, data interface{}) *Error {
+	return rd.RenderLayout(w, r, statusCode, "", name, data)
+}
+
+// Returns an `ErrorHandler` which renders `page` with the data from `data`, which may be nil; e.g. for routes to
+// mostly static pages.
+func (rd *Renderer) Handler(page string, data func(r *http.Request) (interface{}, *Error)) ErrorHandler {
+	return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		var d interface{}
+		if data != nil {
+			var werr *Error
+			if d, werr = data(r); werr != nil {
+				return werr
+			}
+		}
+		return rd.Render(w, r, http.StatusOK, page, d)
+	})
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTemplates(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestRenderer(t *testing.T, dev bool) (*Renderer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"layouts/base.html": `<main>{{block "content" .}}{{end}}</main>`,
+		"page.html":         `{{define "content"}}hi {{.Name}}{{end}}`,
+		"bad.html":          `{{define "content"}}{{.Data.Missing.Field}}{{end}}`,
+		"frag.html":         `<li>{{.Name}}</li>`,
+	})
+
+	set, err := NewTemplateSet("site", dir, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Recursive = true
+	set.Dev = dev
+	rd := NewRenderer(set, nil)
+	if err = set.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return rd, dir
+}
+
+func render(rd *Renderer, layout, page string, data interface{}) (*httptest.ResponseRecorder, *Error) {
+	w := httptest.NewRecorder()
+	werr := rd.RenderLayout(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, layout, page, data)
+	return w, werr
+}
+
+func TestRenderErrorDetails(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	for _, dev := range []bool{false, true} {
+		rd, _ := newTestRenderer(t, dev)
+		for _, page := range []string{"bad.html", "missing.html"} {
+			w, werr := render(rd, "layouts/base.html", page, 1)
+			if werr == nil {
+				t.Fatalf("dev=%v %s: no error", dev, page)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("dev=%v %s: wrote %q", dev, page, w.Body.String())
+			}
+			detailed := strings.Contains(werr.message(), page)
+			if detailed != dev {
+				t.Errorf("dev=%v %s: message %q", dev, page, werr.message())
+			}
+		}
+	}
+}
+
+func TestRenderOverlayOnlyInLayouts(t *testing.T) {
+	rd, dir := newTestRenderer(t, true)
+	writeTemplates(t, dir, map[string]string{"broken.html": `{{if}}`})
+	if err := rd.Set.Load(); err == nil {
+		t.Fatal("broken template loaded")
+	}
+
+	w, werr := render(rd, "layouts/base.html", "page.html", map[string]interface{}{"Name": "Jo"})
+	if werr != nil {
+		t.Fatal(werr.Error)
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "<main>hi Jo</main>") || !strings.Contains(body, "template-error-overlay") {
+		t.Errorf("page %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	if werr = rd.RenderPartial(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, "frag.html", map[string]interface{}{"Name": "Jo"}); werr != nil {
+		t.Fatal(werr.Error)
+	}
+	if body := w.Body.String(); body != "<li>Jo</li>" {
+		t.Errorf("fragment %q", body)
+	}
+}
@@ -0,0 +1,427 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Checks whether a path segment is valid for a typed route parameter, e.g. `{id:int}`.
+type ParamConstraint func(value string) bool
+
+func matchRegexp(pattern string) ParamConstraint {
+	re := regexp.MustCompile(pattern)
+	return re.MatchString
+}
+
+// Built-in parameter types; add your own with `RegisterParamType`.
+var paramTypes = map[string]ParamConstraint{
+	"int":   matchRegexp(`^-?[0-9]+$`),
+	"uint":  matchRegexp(`^[0-9]+$`),
+	"hex":   matchRegexp(`^[0-9a-fA-F]+$`),
+	"alpha": matchRegexp(`^[a-zA-Z]+$`),
+	"alnum": matchRegexp(`^[a-zA-Z0-9]+$`),
+	"slug":  matchRegexp(`^[a-z0-9]+(?:-[a-z0-9]+)*$`),
+	"uuid":  matchRegexp(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+}
+
+// Registers a parameter type for use in route patterns as `{name:type}`. Not safe to call while routing.
+func RegisterParamType(name string, c ParamConstraint) {
+	paramTypes[name] = c
+}
+
+// Parameter values captured from the request path by a `Router`.
+type RouteParams map[string]string
+
+type routeParamsKey struct{}
+
+// Returns the route parameters captured for the request, or nil if it was not routed by a `Router`.
+func Params(r *http.Request) RouteParams {
+	p, _ := r.Context().Value(routeParamsKey{}).(RouteParams)
+	return p
+}
+
+// Returns a single route parameter captured for the request, or "".
+func Param(r *http.Request, name string) string {
+	return Params(r)[name]
+}
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	paramSegment
+	catchAllSegment
+)
+
+type segment struct {
+	kind       segmentKind
+	value      string // literal text or param name
+	typeName   string
+	constraint ParamConstraint
+}
+
+// Parses a pattern like "/users/{id:int}/files/{path...}":
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("route pattern '%s' must start with '/'", pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segs := make([]segment, len(parts))
+	for i, p := range parts {
+		if !strings.HasPrefix(p, "{") || !strings.HasSuffix(p, "}") {
+			if strings.ContainsAny(p, "{}") {
+				return nil, fmt.Errorf("route pattern '%s': parameters must span a whole segment", pattern)
+			}
+			segs[i] = segment{kind: literalSegment, value: p}
+			continue
+		}
+
+		name := p[1 : len(p)-1]
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("route pattern '%s': '{%s}' must be the last segment", pattern, name)
+			}
+			segs[i] = segment{kind: catchAllSegment, value: strings.TrimSuffix(name, "...")}
+			continue
+		}
+
+		s := segment{kind: paramSegment, value: name}
+		if j := strings.IndexByte(name, ':'); j >= 0 {
+			s.value, s.typeName = name[:j], name[j+1:]
+			c, ok := paramTypes[s.typeName]
+			if !ok {
+				return nil, fmt.Errorf("route pattern '%s': unknown parameter type '%s'", pattern, s.typeName)
+			}
+			s.constraint = c
+		}
+		if s.value == "" {
+			return nil, fmt.Errorf("route pattern '%s': parameter without a name", pattern)
+		}
+		segs[i] = s
+	}
+	return segs, nil
+}
+
+func (s segment) matches(value string) bool {
+	return s.constraint == nil || s.constraint(value)
+}
+
+type routeNode struct {
+	literals map[string]*routeNode
+	params   []*routeNode
+	catchAll *routeNode
+
+	seg      segment
+	handlers map[string]ErrorHandler
+}
+
+func (n *routeNode) child(s segment) *routeNode {
+	switch s.kind {
+	case literalSegment:
+		if n.literals == nil {
+			n.literals = make(map[string]*routeNode)
+		}
+		c, ok := n.literals[s.value]
+		if !ok {
+			c = &routeNode{seg: s}
+			n.literals[s.value] = c
+		}
+		return c
+	case paramSegment:
+		for _, c := range n.params {
+			if c.seg.value == s.value && c.seg.typeName == s.typeName {
+				return c
+			}
+		}
+		c := &routeNode{seg: s}
+		n.params = append(n.params, c)
+		return c
+	default:
+		if n.catchAll == nil {
+			n.catchAll = &routeNode{seg: s}
+		}
+		return n.catchAll
+	}
+}
+
+func (n *routeNode) handles(method string) bool {
+	if _, ok := n.handlers[method]; ok {
+		return true
+	}
+	_, ok := n.handlers[http.MethodGet]
+	return ok && method == http.MethodHead
+}
+
+// Finds the node for `parts` with a handler for `method`, preferring literal segments over parameters over
+// catch-alls. Nodes which match the path but not the method are added to `others`, for a 405:
+func (n *routeNode) match(parts []string, method string, params RouteParams, others *[]*routeNode) *routeNode {
+	if len(parts) == 0 {
+		if n.handlers != nil {
+			if n.handles(method) {
+				return n
+			}
+			*others = append(*others, n)
+		}
+		// A catch-all also matches an empty remainder:
+		if c := n.catchAll; c != nil && c.handlers != nil {
+			if c.handles(method) {
+				params[c.seg.value] = ""
+				return c
+			}
+			*others = append(*others, c)
+		}
+		return nil
+	}
+
+	part, rest := parts[0], parts[1:]
+	if c, ok := n.literals[part]; ok {
+		if m := c.match(rest, method, params, others); m != nil {
+			return m
+		}
+	}
+	for _, c := range n.params {
+		if !c.seg.matches(part) {
+			continue
+		}
+		if m := c.match(rest, method, params, others); m != nil {
+			params[c.seg.value] = part
+			return m
+		}
+	}
+	if c := n.catchAll; c != nil && c.handlers != nil {
+		if c.handles(method) {
+			params[c.seg.value] = strings.Join(parts, "/")
+			return c
+		}
+		*others = append(*others, c)
+	}
+	return nil
+}
+
+// A registered route; give it a name to build URLs for it with `Router.URL`.
+type Route struct {
+	router   *Router
+	pattern  string
+	segments []segment
+}
+
+func (rt *Route) Name(name string) *Route {
+	rt.router.named[name] = rt
+	return rt
+}
+
+// A set of routes sharing a path prefix and middleware.
+type RouteGroup struct {
+	router     *Router
+	prefix     string
+	middleware Chain
+}
+
+// Routes requests by path pattern and method to `ErrorHandler`s.
+// Patterns are made of literal segments, parameters like `{id}` or `{id:int}`, and a final catch-all like
+// `{path...}`. A path which matches but not for the request method gets a 405 with an `Allow` header.
+type Router struct {
+	RouteGroup
+
+	root  routeNode
+	named map[string]*Route
+	// Middleware around all requests, from `Router.Use`:
+	chain   Chain
+	handler ErrorHandler
+
+	// Called when no route matches; defaults to a 404 error.
+	NotFound ErrorHandler
+}
+
+func NewRouter() *Router {
+	r := &Router{named: make(map[string]*Route)}
+	r.RouteGroup = RouteGroup{router: r}
+	r.handler = ErrorHandlerFunc(r.dispatch)
+	return r
+}
+
+// Adds middleware around every request the router handles, including those answered with a 404 or 405, e.g. for
+// CORS preflight requests. It applies to routes registered before the call too.
+func (r *Router) Use(mw ...Middleware) {
+	r.chain = r.chain.Append(mw...)
+	r.handler = r.chain.ThenFunc(r.dispatch)
+}
+
+// Creates a nested group; its routes are prefixed with `prefix` and wrapped in `mw` after the parent's middleware.
+func (g *RouteGroup) Group(prefix string, mw ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:     g.router,
+		prefix:     strings.TrimSuffix(g.prefix+prefix, "/"),
+		middleware: g.middleware.Append(mw...),
+	}
+}
+
+// Adds middleware to the group, wrapping the routes registered on it afterwards. Requests which match none of them
+// don't pass through it; see `Router.Use` for that.
+func (g *RouteGroup) Use(mw ...Middleware) {
+	g.middleware = g.middleware.Append(mw...)
+}
+
+// Registers `h` for `method` requests matching `pattern`. Panics if the pattern is invalid or already has a handler
+// for the method. In a group, the pattern "/" is the group's prefix itself, e.g. "/api" rather than "/api/".
+func (g *RouteGroup) Handle(method, pattern string, h ErrorHandler) *Route {
+	if pattern == "/" && g.prefix != "" {
+		pattern = g.prefix
+	} else {
+		pattern = g.prefix + pattern
+	}
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	h = Chain(g.middleware).Then(h)
+
+	n := &g.router.root
+	for _, s := range segs {
+		n = n.child(s)
+	}
+	if n.handlers == nil {
+		n.handlers = make(map[string]ErrorHandler)
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic(fmt.Errorf("route %s %s is already registered", method, pattern))
+	}
+	n.handlers[method] = h
+
+	return &Route{router: g.router, pattern: pattern, segments: segs}
+}
+
+func (g *RouteGroup) HandleFunc(method, pattern string, f ErrorHandlerFunc) *Route {
+	return g.Handle(method, pattern, f)
+}
+
+func (g *RouteGroup) Get(pattern string, h ErrorHandler) *Route {
+	return g.Handle(http.MethodGet, pattern, h)
+}
+
+func (g *RouteGroup) Post(pattern string, h ErrorHandler) *Route {
+	return g.Handle(http.MethodPost, pattern, h)
+}
+
+func (g *RouteGroup) Put(pattern string, h ErrorHandler) *Route {
+	return g.Handle(http.MethodPut, pattern, h)
+}
+
+func (g *RouteGroup) Patch(pattern string, h ErrorHandler) *Route {
+	return g.Handle(http.MethodPatch, pattern, h)
+}
+
+func (g *RouteGroup) Delete(pattern string, h ErrorHandler) *Route {
+	return g.Handle(http.MethodDelete, pattern, h)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) *Error {
+	return r.handler.ServeHTTP(w, req)
+}
+
+func (r *Router) dispatch(w http.ResponseWriter, req *http.Request) *Error {
+	// Split the escaped path, so an escaped "/" stays within its segment:
+	parts := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		var err error
+		if parts[i], err = url.PathUnescape(p); err != nil {
+			return AsError(fmt.Errorf("400 Bad Request: %w", err), http.StatusBadRequest)
+		}
+	}
+
+	params := make(RouteParams)
+	var others []*routeNode
+	n := r.root.match(parts, req.Method, params, &others)
+	if n == nil && len(others) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed(others), ", "))
+		return AsError(errors.New("405 Method Not Allowed"), http.StatusMethodNotAllowed)
+	}
+	if n == nil {
+		if r.NotFound != nil {
+			return r.NotFound.ServeHTTP(w, req)
+		}
+		return AsError(errors.New("404 Not Found"), http.StatusNotFound)
+	}
+
+	h, ok := n.handlers[req.Method]
+	if !ok {
+		h = n.handlers[http.MethodGet]
+	}
+	if len(params) > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), routeParamsKey{}, params))
+	}
+	return h.ServeHTTP(w, req)
+}
+
+// The methods handled by any of `nodes`:
+func allowed(nodes []*routeNode) []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, n := range nodes {
+		for _, m := range n.allowed() {
+			if !seen[m] {
+				seen[m] = true
+				methods = append(methods, m)
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func (n *routeNode) allowed() []string {
+	methods := make([]string, 0, len(n.handlers)+1)
+	for m := range n.handlers {
+		methods = append(methods, m)
+	}
+	if _, ok := n.handlers[http.MethodGet]; ok {
+		if _, ok := n.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Builds the path for the route registered under `name`, filling in its parameters from `params`.
+func (r *Router) URL(name string, params RouteParams) (string, error) {
+	rt, ok := r.named[name]
+	if !ok {
+		return "", fmt.Errorf("no route named '%s'", name)
+	}
+
+	parts := make([]string, len(rt.segments))
+	for i, s := range rt.segments {
+		if s.kind == literalSegment {
+			parts[i] = s.value
+			continue
+		}
+
+		v, ok := params[s.value]
+		if !ok {
+			return "", fmt.Errorf("route '%s' (%s) is missing parameter '%s'", name, rt.pattern, s.value)
+		}
+		if s.kind == catchAllSegment {
+			// Escape each segment but keep the slashes:
+			sub := strings.Split(v, "/")
+			for j := range sub {
+				sub[j] = url.PathEscape(sub[j])
+			}
+			parts[i] = strings.Join(sub, "/")
+			continue
+		}
+		if !s.matches(v) {
+			return "", fmt.Errorf("route '%s' (%s): '%s' is not a valid %s for '%s'", name, rt.pattern, v, s.typeName, s.value)
+		}
+		parts[i] = url.PathEscape(v)
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
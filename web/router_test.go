@@ -0,0 +1,246 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Responds with the route's name and its parameters, e.g. "user id=7":
+func echoRoute(name string) ErrorHandler {
+	return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		out := name
+		for _, k := range []string{"id", "slug", "path", "name"} {
+			if v, ok := Params(r)[k]; ok {
+				out += " " + k + "=" + v
+			}
+		}
+		w.Write([]byte(out))
+		return nil
+	})
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Accept", "text/plain")
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/", echoRoute("root"))
+	rt.Get("/users/me", echoRoute("me"))
+	rt.Get("/users/{id:int}", echoRoute("user"))
+	rt.Get("/users/{slug:slug}", echoRoute("user-slug"))
+	rt.Put("/users/{id:int}", echoRoute("put-user"))
+	rt.Get("/files/{path...}", echoRoute("files"))
+	rt.Get("/files/readme", echoRoute("readme"))
+	rt.Get("/hello/{name}", echoRoute("hello"))
+	h := ReportErrors(rt)
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/", 200, "root"},
+		// Literals win over parameters:
+		{"GET", "/users/me", 200, "me"},
+		{"GET", "/users/7", 200, "user id=7"},
+		{"GET", "/users/-7", 200, "user id=-7"},
+		// Typed parameters are tried in order of registration:
+		{"GET", "/users/john-smith", 200, "user-slug slug=john-smith"},
+		{"GET", "/users/John_Smith", 404, ""},
+		{"PUT", "/users/7", 200, "put-user id=7"},
+		// Catch-alls take the rest, including nothing:
+		{"GET", "/files/a/b/c.txt", 200, "files path=a/b/c.txt"},
+		{"GET", "/files/", 200, "files path="},
+		{"GET", "/files/readme", 200, "readme"},
+		{"GET", "/hello/world", 200, "hello name=world"},
+		{"GET", "/hello", 404, ""},
+		{"GET", "/hello/world/extra", 404, ""},
+		{"GET", "/nope", 404, ""},
+		// HEAD falls back to GET:
+		{"HEAD", "/users/7", 200, "user id=7"},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.path)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status == 200 && w.Body.String() != tt.body {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id:int}", echoRoute("user"))
+	rt.Delete("/users/{id:int}", echoRoute("delete"))
+
+	w := serve(ReportErrors(rt), "POST", "/users/7")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD" {
+		t.Fatalf("Allow: %q", allow)
+	}
+}
+
+func TestRouterMethodFallback(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/me", echoRoute("me"))
+	rt.Post("/users/{id}", echoRoute("post-user"))
+	h := ReportErrors(rt)
+
+	// The literal only has GET, so POST falls back to the parameter:
+	if w := serve(h, "POST", "/users/me"); w.Code != 200 || w.Body.String() != "post-user id=me" {
+		t.Errorf("POST /users/me: %d %q", w.Code, w.Body.String())
+	}
+	w := serve(h, "DELETE", "/users/me")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE /users/me: status %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Errorf("Allow: %q", allow)
+	}
+}
+
+func TestRouterUseWrapsUnmatched(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/items/{id:int}", echoRoute("item"))
+	rt.Use(CORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+	h := ReportErrors(rt)
+
+	// A preflight for a route without OPTIONS:
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("OPTIONS", "/items/1", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("preflight: %d %v", w.Code, w.Header())
+	}
+
+	// Registered before Use, still wrapped:
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/items/1", nil)
+	r.Header.Set("Origin", "https://example.com")
+	h.ServeHTTP(w, r)
+	if w.Code != 200 || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("GET: %d %v", w.Code, w.Header())
+	}
+
+	// And so are 404s:
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/nope", nil)
+	r.Header.Set("Origin", "https://example.com")
+	h.ServeHTTP(w, r)
+	if w.Code != 404 || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("404: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next ErrorHandler) ErrorHandler {
+			return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+				calls = append(calls, name)
+				return next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	rt := NewRouter()
+	rt.Use(mw("root"))
+	api := rt.Group("/api", mw("api"))
+	api.Get("/", echoRoute("api-index"))
+	v1 := api.Group("/v1/")
+	v1.Get("/", echoRoute("v1-index"))
+	v1.Get("/users/{id:int}", echoRoute("v1-user"))
+	h := ReportErrors(rt)
+
+	for path, want := range map[string]string{
+		"/api":            "api-index",
+		"/api/v1":         "v1-index",
+		"/api/v1/users/3": "v1-user id=3",
+	} {
+		calls = nil
+		w := serve(h, "GET", path)
+		if w.Code != 200 || w.Body.String() != want {
+			t.Errorf("GET %s: %d %q, want %q", path, w.Code, w.Body.String(), want)
+		}
+		if strings.Join(calls, ",") != "root,api" {
+			t.Errorf("GET %s: middleware ran as %v", path, calls)
+		}
+	}
+	if w := serve(h, "GET", "/api/"); w.Code != 404 {
+		t.Errorf("GET /api/: status %d", w.Code)
+	}
+}
+
+func TestRouterURL(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id:int}", echoRoute("user")).Name("user")
+	rt.Get("/files/{path...}", echoRoute("files")).Name("files")
+	rt.Get("/hello/{name}", echoRoute("hello")).Name("hello")
+	rt.Group("/api").Get("/", echoRoute("api")).Name("api")
+
+	tests := []struct {
+		name   string
+		params RouteParams
+		want   string
+	}{
+		{"user", RouteParams{"id": "42"}, "/users/42"},
+		{"files", RouteParams{"path": "a b/c?d.txt"}, "/files/a%20b/c%3Fd.txt"},
+		{"hello", RouteParams{"name": "x/y"}, "/hello/x%2Fy"},
+		{"api", nil, "/api"},
+	}
+	for _, tt := range tests {
+		got, err := rt.URL(tt.name, tt.params)
+		if err != nil || got != tt.want {
+			t.Errorf("URL(%s, %v) = %q, %v; want %q", tt.name, tt.params, got, err, tt.want)
+		}
+	}
+
+	if _, err := rt.URL("user", RouteParams{"id": "abc"}); err == nil {
+		t.Error("URL accepted a non-int id")
+	}
+	if _, err := rt.URL("user", nil); err == nil {
+		t.Error("URL accepted a missing id")
+	}
+	if _, err := rt.URL("nope", nil); err == nil {
+		t.Error("URL accepted an unknown route")
+	}
+}
+
+func TestRouterURLRoundTrip(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/hello/{name}", echoRoute("hello")).Name("hello")
+	rt.Get("/hello/{name}/files/{path...}", echoRoute("files")).Name("files")
+	h := ReportErrors(rt)
+
+	for _, tt := range []struct {
+		route  string
+		params RouteParams
+		want   string
+	}{
+		{"hello", RouteParams{"name": "x/y"}, "hello name=x/y"},
+		{"hello", RouteParams{"name": "a b%c?"}, "hello name=a b%c?"},
+		{"files", RouteParams{"name": "x/y", "path": "a b/c"}, "files path=a b/c name=x/y"},
+	} {
+		u, err := rt.URL(tt.route, tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := serve(h, "GET", u); w.Code != 200 || w.Body.String() != tt.want {
+			t.Errorf("GET %s: %d %q, want %q", u, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JamesDunne/go-util/fs/notify"
+)
+
+// A Server-Sent Event.
+type Event struct {
+	// Assigned by `Hub.Publish` if empty. Sent back by reconnecting clients as `Last-Event-ID`.
+	ID string
+	// Event type; clients listen with `addEventListener(type, ...)`. Empty for "message".
+	Event string
+	// May span multiple lines.
+	Data string
+	// Reconnection delay hint for the client; zero to leave unchanged. An event with only this set isn't dispatched.
+	Retry time.Duration
+}
+
+// Creates an event whose data is `v` as JSON.
+func JSONEvent(event string, v interface{}) (Event, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Event: event, Data: string(j)}, nil
+}
+
+// Creates a "change" event for a file change reported by `notify.Watcher`, with data like
+// `{"name":"html/index.html","op":"MODIFY"}`.
+func FileChangeEvent(ev *notify.FileEvent) Event {
+	op := ev.String()
+	if i := strings.LastIndex(op, ": "); i >= 0 {
+		op = op[i+2:]
+	}
+	e, _ := JSONEvent("change", struct {
+		Name string `json:"name"`
+		Op   string `json:"op"`
+	}{
+		Name: ev.Name,
+		Op:   op,
+	})
+	return e
+}
+
+// Writes the event in the `text/event-stream` format.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + oneLine(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + oneLine(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	// Each line of the data gets its own field; the client joins them with newlines. Without any data field a
+	// retry-only event just updates the client's hint:
+	if !(e.ID == "" && e.Event == "" && e.Data == "" && e.Retry > 0) {
+		data := strings.Replace(e.Data, "\r\n", "\n", -1)
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Line breaks would end the field early:
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Returns the ID of the last event the client saw, from the `Last-Event-ID` header or else the `lastEventId` query
+// parameter (for polyfills which can't set headers).
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// A `text/event-stream` response for sending events to a single client.
+type EventStream struct {
+	w    http.ResponseWriter
+	f    http.Flusher
+	done <-chan struct{}
+
+	lock sync.Mutex
+}
+
+// Starts an event stream response. Fails with a 500 if the response can't be flushed incrementally.
+func NewEventStream(w http.ResponseWriter, r *http.Request) (*EventStream, *Error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, AsError(errors.New("streaming is not supported by this response writer"), http.StatusInternalServerError)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream:
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	return &EventStream{w: w, f: f, done: r.Context().Done()}, nil
+}
+
+// Sends an event and flushes it to the client.
+func (s *EventStream) Send(e Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := e.WriteTo(s.w); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+// Sends a comment, which clients ignore; useful to keep idle connections open through proxies.
+func (s *EventStream) Comment(text string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := io.WriteString(s.w, ": "+oneLine(text)+"\n\n"); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+// Closed when the client disconnects.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+///////////////////////////////////////////////////////////
+
+const (
+	defaultHeartbeat    = 15 * time.Second
+	defaultClientBuffer = 16
+	defaultPollTimeout  = 30 * time.Second
+)
+
+// Broadcasts events to all connected clients, keeping the most recent ones so reconnecting clients can resume from
+// their `Last-Event-ID`. Serve it as an `ErrorHandler` for SSE, or use `LongPoll` for clients without `EventSource`.
+type Hub struct {
+	// Sent on connect as a reconnection delay hint; zero leaves the browser default.
+	Retry time.Duration
+	// Interval between heartbeat comments to idle clients; defaults to 15s.
+	Heartbeat time.Duration
+	// Events queued per client before it's considered too slow and disconnected (to resume from the replay buffer
+	// when it reconnects); defaults to 16.
+	ClientBuffer int
+	// How long `LongPoll` waits for new events; defaults to 30s.
+	PollTimeout time.Duration
+
+	lock    sync.Mutex
+	lastID  uint64
+	replay  []Event
+	size    int
+	clients map[chan Event]struct{}
+	// Closed and replaced on every publish, to wake long-pollers:
+	published chan struct{}
+	closed    chan struct{}
+}
+
+// Creates a hub which keeps the last `replaySize` events for resuming clients.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		size:      replaySize,
+		clients:   make(map[chan Event]struct{}),
+		published: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (h *Hub) heartbeat() time.Duration {
+	if h.Heartbeat <= 0 {
+		return defaultHeartbeat
+	}
+	return h.Heartbeat
+}
+
+func (h *Hub) clientBuffer() int {
+	if h.ClientBuffer <= 0 {
+		return defaultClientBuffer
+	}
+	return h.ClientBuffer
+}
+
+func (h *Hub) pollTimeout() time.Duration {
+	if h.PollTimeout <= 0 {
+		return defaultPollTimeout
+	}
+	return h.PollTimeout
+}
+
+// Sends `e` to all connected clients, assigning it the next sequential ID unless it has one. Returns the event as sent.
+func (h *Hub) Publish(e Event) Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(h.lastID, 10)
+	}
+
+	if h.size > 0 {
+		if len(h.replay) == h.size {
+			h.replay = append(h.replay[:0], h.replay[1:]...)
+		}
+		h.replay = append(h.replay, e)
+	}
+
+	for c := range h.clients {
+		select {
+		case c <- e:
+		default:
+			// Too slow; disconnect it:
+			delete(h.clients, c)
+			close(c)
+		}
+	}
+
+	close(h.published)
+	h.published = make(chan struct{})
+	return e
+}
+
+// Publishes an event whose data is `v` as JSON.
+func (h *Hub) PublishJSON(event string, v interface{}) (Event, error) {
+	e, err := JSONEvent(event, v)
+	if err != nil {
+		return Event{}, err
+	}
+	return h.Publish(e), nil
+}
+
+// Returns the buffered events after the one with ID `lastID`, or all of them if it's no longer buffered:
+func (h *Hub) since(lastID string) []Event {
+	if lastID == "" {
+		return nil
+	}
+	for i := len(h.replay) - 1; i >= 0; i-- {
+		if h.replay[i].ID == lastID {
+			return append([]Event(nil), h.replay[i+1:]...)
+		}
+	}
+	return append([]Event(nil), h.replay...)
+}
+
+// Number of connected SSE clients.
+func (h *Hub) Clients() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.clients)
+}
+
+// Disconnects all clients and refuses new ones.
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	select {
+	case <-h.closed:
+		return
+	default:
+	}
+	close(h.closed)
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+func (h *Hub) isClosed() bool {
+	select {
+	case <-h.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Streams events to the client until it disconnects, resuming after its `Last-Event-ID`.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) *Error {
+	if h.isClosed() {
+		return AsError(errors.New("event hub is closed"), http.StatusServiceUnavailable)
+	}
+
+	s, werr := NewEventStream(w, r)
+	if werr != nil {
+		return werr
+	}
+
+	// Subscribe and take the missed events atomically, so nothing falls in between:
+	c := make(chan Event, h.clientBuffer())
+	h.lock.Lock()
+	missed := h.since(LastEventID(r))
+	h.clients[c] = struct{}{}
+	h.lock.Unlock()
+	defer h.unsubscribe(c)
+
+	if h.Retry > 0 {
+		if err := s.Send(Event{Retry: h.Retry}); err != nil {
+			return nil
+		}
+	}
+	for _, e := range missed {
+		if err := s.Send(e); err != nil {
+			return nil
+		}
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				// Dropped for being slow, or the hub closed:
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return nil
+			}
+		case <-s.Done():
+			return nil
+		}
+	}
+}
+
+func (h *Hub) unsubscribe(c chan Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+// Returns an `ErrorHandler` which responds with a JSON array of `{id,event,data}` objects for the events after the
+// client's `Last-Event-ID` (or `lastEventId` parameter), waiting up to `PollTimeout` for one if there are none. A
+// client without an ID gets only new events. Responds with 204 if nothing was published in time. Needs a replay
+// buffer to work.
+func (h *Hub) LongPoll() ErrorHandler {
+	return ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) *Error {
+		lastID := LastEventID(r)
+		timeout := time.NewTimer(h.pollTimeout())
+		defer timeout.Stop()
+
+		for {
+			h.lock.Lock()
+			events := h.since(lastID)
+			if lastID == "" && len(h.replay) > 0 {
+				// Start from the current position:
+				lastID = h.replay[len(h.replay)-1].ID
+			}
+			published := h.published
+			h.lock.Unlock()
+
+			if len(events) > 0 {
+				return writePolledEvents(w, events)
+			}
+
+			select {
+			case <-published:
+				if lastID == "" {
+					// Nothing was buffered before; take whatever is now:
+					h.lock.Lock()
+					events = append([]Event(nil), h.replay...)
+					h.lock.Unlock()
+					if len(events) > 0 {
+						return writePolledEvents(w, events)
+					}
+				}
+			case <-timeout.C:
+				w.WriteHeader(http.StatusNoContent)
+				return nil
+			case <-h.closed:
+				return AsError(errors.New("event hub is closed"), http.StatusServiceUnavailable)
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+	})
+}
+
+type polledEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+func writePolledEvents(w http.ResponseWriter, events []Event) *Error {
+	out := make([]polledEvent, len(events))
+	for i, e := range events {
+		out[i] = polledEvent{ID: e.ID, Event: e.Event, Data: e.Data}
+	}
+
+	j, err := json.Marshal(out)
+	if err != nil {
+		return AsError(fmt.Errorf("marshaling events: %w", err), http.StatusInternalServerError)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+	return nil
+}
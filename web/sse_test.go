@@ -0,0 +1,234 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHubServer(t *testing.T, h *Hub) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/events", ReportErrors(h))
+	mux.Handle("/poll", ReportErrors(h.LongPoll()))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Connects to the event stream, resuming after `lastID` unless it's "":
+func connectEvents(t *testing.T, srv *httptest.Server, lastID string) (*bufio.Reader, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rsp.Body.Close() })
+	if ct := rsp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type: %q", ct)
+	}
+	return bufio.NewReader(rsp.Body), cancel
+}
+
+// Reads the next dispatched event, skipping comments and retry hints:
+func readEvent(t *testing.T, r *bufio.Reader) Event {
+	t.Helper()
+	var e Event
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.ID == "" && e.Event == "" && data == nil {
+				continue
+			}
+			e.Data = strings.Join(data, "\n")
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.ID = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			e.Event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, line[6:])
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventWriteTo(t *testing.T) {
+	var b strings.Builder
+	Event{ID: "7", Event: "up\ndate", Data: "a\r\nb", Retry: 1500 * time.Millisecond}.WriteTo(&b)
+	if want := "id: 7\nevent: update\nretry: 1500\ndata: a\ndata: b\n\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	Event{Retry: time.Second}.WriteTo(&b)
+	if b.String() != "retry: 1000\n\n" {
+		t.Errorf("retry only: %q", b.String())
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	h := NewHub(10)
+	defer h.Close()
+	srv := newHubServer(t, h)
+	for _, d := range []string{"a", "b", "c"} {
+		h.Publish(Event{Data: d})
+	}
+
+	for _, tt := range []struct {
+		lastID string
+		want   []string
+	}{
+		// Resumes after a buffered event:
+		{"1", []string{"2:b", "3:c"}},
+		// Replays everything buffered for an ID it doesn't know:
+		{"gone", []string{"1:a", "2:b", "3:c"}},
+	} {
+		r, cancel := connectEvents(t, srv, tt.lastID)
+		for _, want := range tt.want {
+			if e := readEvent(t, r); e.ID+":"+e.Data != want {
+				t.Errorf("Last-Event-ID %s: got %s:%s, want %s", tt.lastID, e.ID, e.Data, want)
+			}
+		}
+		cancel()
+	}
+}
+
+func TestHubLiveAndDisconnect(t *testing.T) {
+	h := NewHub(10)
+	defer h.Close()
+	h.Retry = 2 * time.Second
+	srv := newHubServer(t, h)
+
+	r, cancel := connectEvents(t, srv, "")
+	waitFor(t, "the client to subscribe", func() bool { return h.Clients() == 1 })
+
+	h.PublishJSON("user", map[string]int{"id": 7})
+	e := readEvent(t, r)
+	if e.ID != "1" || e.Event != "user" || e.Data != `{"id":7}` {
+		t.Errorf("got %+v", e)
+	}
+
+	// The client going away unsubscribes it:
+	cancel()
+	waitFor(t, "the client to be dropped", func() bool { return h.Clients() == 0 })
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	h := NewHub(0)
+	c := make(chan Event, 1)
+	h.clients[c] = struct{}{}
+
+	h.Publish(Event{Data: "1"})
+	h.Publish(Event{Data: "2"})
+	if h.Clients() != 0 {
+		t.Fatal("slow client still subscribed")
+	}
+	if e := <-c; e.Data != "1" {
+		t.Errorf("got %+v", e)
+	}
+	if _, ok := <-c; ok {
+		t.Error("slow client's channel not closed")
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(10)
+	srv := newHubServer(t, h)
+
+	r, _ := connectEvents(t, srv, "")
+	waitFor(t, "the client to subscribe", func() bool { return h.Clients() == 1 })
+	h.Close()
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("stream still open after Close")
+	}
+
+	rsp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d after Close", rsp.StatusCode)
+	}
+}
+
+func poll(t *testing.T, srv *httptest.Server, lastID string) (int, []polledEvent) {
+	t.Helper()
+	u := srv.URL + "/poll"
+	if lastID != "" {
+		u += "?lastEventId=" + lastID
+	}
+	rsp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	var events []polledEvent
+	if rsp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(rsp.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rsp.StatusCode, events
+}
+
+func TestHubLongPoll(t *testing.T) {
+	h := NewHub(10)
+	defer h.Close()
+	h.PollTimeout = 50 * time.Millisecond
+	srv := newHubServer(t, h)
+
+	// Nothing published in time:
+	if status, _ := poll(t, srv, ""); status != http.StatusNoContent {
+		t.Errorf("status %d, want 204", status)
+	}
+
+	h.Publish(Event{Data: "a"})
+	h.Publish(Event{Data: "b"})
+	if status, events := poll(t, srv, "1"); status != http.StatusOK || len(events) != 1 || events[0].Data != "b" {
+		t.Errorf("resume: %d %+v", status, events)
+	}
+	// A client without an ID only gets new events:
+	if status, _ := poll(t, srv, ""); status != http.StatusNoContent {
+		t.Errorf("no ID: status %d, want 204", status)
+	}
+
+	// A waiting poller is woken by a publish:
+	h.PollTimeout = 2 * time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.Publish(Event{Event: "late", Data: "c"})
+	}()
+	if status, events := poll(t, srv, "2"); status != http.StatusOK || len(events) != 1 || events[0].Event != "late" {
+		t.Errorf("woken: %d %+v", status, events)
+	}
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"time"
+)
+
+// Returns the standard template functions; `Renderer` adds these to its templates, along with `url` and `partial`:
+//
+//	date "2006-01-02" t       formats a time.Time or *time.Time ("" for nil or zero)
+//	rfc3339 t                 formats a time for machines, e.g. in <time datetime="...">
+//	ago t                     describes a past time relative to now, e.g. "5 minutes ago"
+//	now                       the current time
+//	plural n "item" ["items"] the singular or plural word for count n; the plural defaults to singular + "s"
+//	dict "k1" v1 "k2" v2      builds a map, e.g. to pass several values to a partial
+//	json v                    v as JSON, safe to embed in a <script>
+//	safeHTML, safeAttr, safeCSS, safeJS, safeURL
+//	                          mark trusted strings as not needing escaping in their context; never use these on
+//	                          user input
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"date":     formatDate,
+		"rfc3339":  func(t interface{}) (string, error) { return formatDate(time.RFC3339, t) },
+		"ago":      ago,
+		"now":      time.Now,
+		"plural":   plural,
+		"dict":     dict,
+		"json":     embedJSON,
+		"safeHTML": func(s string) template.HTML { return template.HTML(s) },
+		"safeAttr": func(s string) template.HTMLAttr { return template.HTMLAttr(s) },
+		"safeCSS":  func(s string) template.CSS { return template.CSS(s) },
+		"safeJS":   func(s string) template.JS { return template.JS(s) },
+		"safeURL":  func(s string) template.URL { return template.URL(s) },
+	}
+}
+
+// Returns a zero time for nil:
+func asTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return *t, nil
+	default:
+		return time.Time{}, fmt.Errorf("expected a time.Time, got %T", v)
+	}
+}
+
+func formatDate(layout string, v interface{}) (string, error) {
+	t, err := asTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+func ago(v interface{}) (string, error) {
+	t, err := asTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+
+	d := time.Since(t)
+	if d < 0 {
+		return "in the future", nil
+	}
+
+	units := []struct {
+		d    time.Duration
+		name string
+	}{
+		{365 * 24 * time.Hour, "year"},
+		{30 * 24 * time.Hour, "month"},
+		{7 * 24 * time.Hour, "week"},
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	}
+	for _, u := range units {
+		if d >= u.d {
+			n := int64(d / u.d)
+			if n == 1 {
+				return fmt.Sprintf("1 %s ago", u.name), nil
+			}
+			return fmt.Sprintf("%d %ss ago", n, u.name), nil
+		}
+	}
+	return "just now", nil
+}
+
+func plural(n interface{}, singular string, pluralForm ...string) (string, error) {
+	var count float64
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		count = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		count = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		count = v.Float()
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		count = float64(v.Len())
+	default:
+		return "", fmt.Errorf("plural: expected a number, got %T", n)
+	}
+
+	if count == 1 || count == -1 {
+		return singular, nil
+	}
+	if len(pluralForm) > 0 {
+		return pluralForm[0], nil
+	}
+	return singular + "s", nil
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: expected key/value pairs")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[k] = pairs[i+1]
+	}
+	return m, nil
+}
+
+func embedJSON(v interface{}) (template.JS, error) {
+	// json.Marshal escapes <, >, & and the line separators U+2028/U+2029, so the result can't break out of a
+	// <script>:
+	j, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(j), nil
+}
@@ -0,0 +1,348 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JamesDunne/go-util/base"
+	"github.com/JamesDunne/go-util/fs/notify"
+)
+
+const defaultTemplateDebounce = 100 * time.Millisecond
+
+// A set of templates parsed from the files in a directory matching a glob, which can be reloaded when they change.
+// Safe for concurrent use; reloads swap in the new templates atomically and keep the old ones if parsing fails.
+type TemplateSet struct {
+	// Name of the root template.
+	Name string
+	// Called on each new root template before parsing, e.g. to add a function map.
+	PreParse func(*template.Template) *template.Template
+	// Also parse templates in subdirectories, named by their path relative to the directory, e.g. "partials/nav.html".
+	Recursive bool
+	// How long to wait for changes to settle before reloading; defaults to 100ms.
+	Debounce time.Duration
+	// Render an overlay with the last reload error on top of pages; see `ExecuteTemplate`.
+	Dev bool
+	// Called after each reload attempt with the templates in use and the error, if any; e.g. to publish a reload event
+	// to a `Hub`.
+	OnReload func(t *template.Template, err error)
+
+	dir  string
+	glob string
+
+	current atomic.Value // *templateSnapshot
+	lock    sync.Mutex
+	lastErr error
+
+	watcher *notify.Watcher
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Creates a set for the templates in `dir` matching `glob`, e.g. "*.html". Call `Load` or `Watch` to parse them.
+func NewTemplateSet(name, dir, glob string) (*TemplateSet, error) {
+	dir, err := base.CanonicalPathErr(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	return &TemplateSet{Name: name, dir: dir, glob: glob}, nil
+}
+
+func (s *TemplateSet) Dir() string { return s.dir }
+
+// The templates from one successful load, with the source of each file for a `Renderer` to compose pages from:
+type templateSnapshot struct {
+	t       *template.Template
+	names   []string
+	sources map[string]string
+}
+
+func (s *TemplateSet) snapshot() *templateSnapshot {
+	snap, _ := s.current.Load().(*templateSnapshot)
+	return snap
+}
+
+// Returns the current templates, or nil if none have parsed successfully yet.
+func (s *TemplateSet) Template() *template.Template {
+	if snap := s.snapshot(); snap != nil {
+		return snap.t
+	}
+	return nil
+}
+
+// Returns the error from the last load, or nil if it succeeded.
+func (s *TemplateSet) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastErr
+}
+
+// Lists the template files, sorted, with their names relative to the directory:
+func (s *TemplateSet) files() (files []string, err error) {
+	if !s.Recursive {
+		files, err = filepath.Glob(filepath.Join(s.dir, s.glob))
+		sort.Strings(files)
+		return
+	}
+
+	err = filepath.Walk(s.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if ok, _ := filepath.Match(s.glob, fi.Name()); ok {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *TemplateSet) parse() (*templateSnapshot, error) {
+	t := template.New(s.Name)
+	if s.PreParse != nil {
+		t = s.PreParse(t)
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no templates match '%s' in '%s'", s.glob, s.dir)
+	}
+
+	snap := &templateSnapshot{t: t, sources: make(map[string]string, len(files))}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(s.dir, file)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.ToSlash(rel)
+		if _, err = t.New(name).Parse(string(b)); err != nil {
+			return nil, err
+		}
+		snap.names = append(snap.names, name)
+		snap.sources[name] = string(b)
+	}
+	return snap, nil
+}
+
+// Parses the templates now. On success they replace the current ones; either way the result is recorded for `Err`.
+func (s *TemplateSet) Load() error {
+	snap, err := s.parse()
+
+	s.lock.Lock()
+	s.lastErr = err
+	s.lock.Unlock()
+
+	if err == nil {
+		s.current.Store(snap)
+	}
+	if s.OnReload != nil {
+		s.OnReload(s.Template(), err)
+	}
+	return err
+}
+
+// Whether a changed file may affect the templates:
+func (s *TemplateSet) relevant(name string) bool {
+	if ok, _ := filepath.Match(s.glob, filepath.Base(name)); ok {
+		return true
+	}
+	// New, removed or renamed directories may hold templates:
+	if s.Recursive && name != s.dir {
+		if fi, err := os.Stat(name); err != nil || fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads the templates and reloads them whenever a matching file changes, until `Close`. Fails if they don't parse
+// initially, unless in `Dev` mode, where the error is shown until it's fixed.
+func (s *TemplateSet) Watch() error {
+	if s.watcher != nil {
+		return fmt.Errorf("templates in '%s' are already watched", s.dir)
+	}
+	if err := s.Load(); err != nil && !s.Dev {
+		return err
+	}
+
+	w, err := notify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = s.watchDirs(w, s.dir); err != nil {
+		w.Close()
+		return err
+	}
+
+	s.watcher = w
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(w)
+	return nil
+}
+
+// Watches `dir`, and with `Recursive` its subdirectories:
+func (s *TemplateSet) watchDirs(w *notify.Watcher, dir string) error {
+	if !s.Recursive {
+		return w.Watch(dir)
+	}
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		return w.Watch(path)
+	})
+}
+
+func (s *TemplateSet) run(w *notify.Watcher) {
+	defer close(s.done)
+
+	debounce := s.Debounce
+	if debounce <= 0 {
+		debounce = defaultTemplateDebounce
+	}
+
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Event:
+			if !ok {
+				return
+			}
+			if ev == nil || !s.relevant(ev.Name) {
+				continue
+			}
+			if s.Recursive && ev.IsCreate() {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err = s.watchDirs(w, ev.Name); err != nil {
+						log.Println("template watcher:", err)
+					}
+				}
+			}
+
+			// Wait for the changes to settle:
+			if timer == nil {
+				timer = time.NewTimer(debounce)
+			} else {
+				timer.Stop()
+				timer.Reset(debounce)
+			}
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			if err := s.Load(); err != nil {
+				log.Println("template reload:", err)
+			}
+		case err, ok := <-w.Error:
+			if !ok {
+				return
+			}
+			if err != nil {
+				log.Println("template watcher:", err)
+			}
+		case <-s.stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Stops watching and waits for a pending reload to finish.
+func (s *TemplateSet) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	w := s.watcher
+	s.watcher = nil
+
+	err := w.Close()
+	close(s.stop)
+	<-s.done
+
+	// Let the watcher's goroutines finish delivering:
+	go func() {
+		for range w.Event {
+		}
+	}()
+	go func() {
+		for range w.Error {
+		}
+	}()
+	return err
+}
+
+// Executes the named template. In `Dev` mode, if the last reload failed the error overlay is written after the page
+// (or instead of it if nothing has parsed yet).
+func (s *TemplateSet) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	t := s.Template()
+	lastErr := s.Err()
+	if t == nil {
+		if lastErr == nil {
+			return fmt.Errorf("templates in '%s' are not loaded", s.dir)
+		}
+		if !s.Dev {
+			return lastErr
+		}
+		return writeErrorOverlay(w, lastErr, false)
+	}
+
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		return err
+	}
+	if s.Dev && lastErr != nil {
+		return writeErrorOverlay(w, lastErr, true)
+	}
+	return nil
+}
+
+// Returns the overlay describing the last reload error, or "" if there is none or not in `Dev` mode.
+func (s *TemplateSet) ErrorOverlay() template.HTML {
+	lastErr := s.Err()
+	if !s.Dev || lastErr == nil {
+		return ""
+	}
+
+	var b bytes.Buffer
+	writeErrorOverlay(&b, lastErr, s.Template() != nil)
+	return template.HTML(b.String())
+}
+
+var errorOverlayTemplate = template.Must(template.New("overlay").Parse(`<div id="template-error-overlay" style="position:fixed;left:0;right:0;bottom:0;z-index:2147483647;max-height:50%;overflow:auto;margin:0;padding:1em 1.5em;background:#2b0000;color:#ffd7d7;border-top:4px solid #e00;font:13px/1.5 monospace;white-space:pre-wrap"><strong>Template error</strong>{{if .Stale}} (showing the last good templates){{end}}
+{{.Error}}</div>
+`))
+
+func writeErrorOverlay(w io.Writer, err error, stale bool) error {
+	return errorOverlayTemplate.Execute(w, struct {
+		Error string
+		Stale bool
+	}{err.Error(), stale})
+}
@@ -0,0 +1,208 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the results of a set's reloads:
+type reloads struct {
+	lock sync.Mutex
+	errs []error
+	c    chan error
+}
+
+func watchReloads(s *TemplateSet) *reloads {
+	r := &reloads{c: make(chan error, 100)}
+	s.OnReload = func(t *template.Template, err error) {
+		r.lock.Lock()
+		r.errs = append(r.errs, err)
+		r.lock.Unlock()
+		r.c <- err
+	}
+	return r
+}
+
+func (r *reloads) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.errs)
+}
+
+func (r *reloads) next(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.c:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a reload")
+		return nil
+	}
+}
+
+func execute(t *testing.T, s *TemplateSet, name string) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := s.ExecuteTemplate(&b, name, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func newWatchedSet(t *testing.T, dev bool) (*TemplateSet, *reloads, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{"page.html": "v1"})
+
+	s, err := NewTemplateSet("site", dir, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Debounce = 50 * time.Millisecond
+	s.Dev = dev
+	r := watchReloads(s)
+	if err = s.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err = r.next(t); err != nil {
+		t.Fatal(err)
+	}
+	return s, r, dir
+}
+
+func TestTemplateSetDebouncedReload(t *testing.T) {
+	s, r, dir := newWatchedSet(t, false)
+
+	// A burst of changes is reloaded once they settle:
+	for i := 2; i <= 4; i++ {
+		writeTemplates(t, dir, map[string]string{"page.html": "v" + strconv.Itoa(i)})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := r.next(t); err != nil {
+		t.Fatal(err)
+	}
+	if got := execute(t, s, "page.html"); got != "v4" {
+		t.Errorf("got %q after reload", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := r.count(); n != 2 {
+		t.Errorf("%d loads, want the initial one and a single reload", n)
+	}
+
+	// Files not matching the glob are ignored:
+	writeTemplates(t, dir, map[string]string{"notes.txt": "x"})
+	time.Sleep(200 * time.Millisecond)
+	if n := r.count(); n != 2 {
+		t.Errorf("%d loads after an unrelated change", n)
+	}
+}
+
+func TestTemplateSetKeepsLastGood(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	s, r, dir := newWatchedSet(t, false)
+
+	writeTemplates(t, dir, map[string]string{"page.html": "{{if}}"})
+	if err := r.next(t); err == nil {
+		t.Fatal("broken template reloaded without an error")
+	}
+	if s.Err() == nil {
+		t.Error("Err is nil after a failed reload")
+	}
+	if got := execute(t, s, "page.html"); got != "v1" {
+		t.Errorf("got %q, want the last good template", got)
+	}
+	if s.ErrorOverlay() != "" {
+		t.Error("overlay outside Dev mode")
+	}
+
+	writeTemplates(t, dir, map[string]string{"page.html": "fixed"})
+	if err := r.next(t); err != nil {
+		t.Fatal(err)
+	}
+	if s.Err() != nil {
+		t.Errorf("Err: %v", s.Err())
+	}
+	if got := execute(t, s, "page.html"); got != "fixed" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTemplateSetDevOverlay(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	// Dev mode starts even when nothing parses, showing the error instead:
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{"page.html": "{{<script>"})
+	s, err := NewTemplateSet("site", dir, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Debounce = 50 * time.Millisecond
+	s.Dev = true
+	r := watchReloads(s)
+	if err = s.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r.next(t)
+
+	got := execute(t, s, "page.html")
+	if !strings.Contains(got, "template-error-overlay") || strings.Contains(got, "showing the last good") {
+		t.Errorf("got %q", got)
+	}
+	if strings.Contains(got, "<script>") {
+		t.Errorf("overlay not escaped: %q", got)
+	}
+
+	writeTemplates(t, dir, map[string]string{"page.html": "ok"})
+	if err = r.next(t); err != nil {
+		t.Fatal(err)
+	}
+	writeTemplates(t, dir, map[string]string{"page.html": "{{end}}"})
+	r.next(t)
+
+	got = execute(t, s, "page.html")
+	if !strings.HasPrefix(got, "ok<div id=\"template-error-overlay\"") || !strings.Contains(got, "showing the last good") {
+		t.Errorf("got %q", got)
+	}
+	if !strings.Contains(string(s.ErrorOverlay()), "template-error-overlay") {
+		t.Error("no overlay from ErrorOverlay")
+	}
+}
+
+func TestTemplateSetClose(t *testing.T) {
+	s, r, dir := newWatchedSet(t, false)
+	done := s.done
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("watch goroutine still running after Close")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	writeTemplates(t, dir, map[string]string{"page.html": "v2"})
+	time.Sleep(200 * time.Millisecond)
+	if n := r.count(); n != 1 {
+		t.Errorf("%d loads after Close", n)
+	}
+	if got := execute(t, s, "page.html"); got != "v1" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -13,12 +13,17 @@ const (
 	HTML
 	JSON
 	Empty
+	Text
+	// RFC 7807 application/problem+json
+	Problem
 )
 
 type Error struct {
 	ResponseKind ResponseKind
 	StatusCode   int
 	Error        error
+	// Optional RFC 7807 details for `Problem` responses.
+	Problem *ProblemDetails
 }
 
 // Override this to provide custom web error logging:
@@ -84,37 +89,45 @@ func AsErrorJSON(err error, statusCode int) *Error {
 	}
 }
 
+// Writes the error response in its `ResponseKind`. With no request to negotiate from, `Undetermined` errors are
+// written as `DefaultResponseKind`; prefer `RespondTo`.
 func (e *Error) Respond(rsp http.ResponseWriter) bool {
+	return e.RespondTo(rsp, nil)
+}
+
+// Writes the error response, resolving an `Undetermined` kind from the request's `Accept` header.
+func (e *Error) RespondTo(rsp http.ResponseWriter, req *http.Request) bool {
 	if e == nil {
 		return false
 	}
 
-	if e.ResponseKind == HTML {
-		rsp.Header().Set("Content-Type", "text/html; charset=utf-8")
-		rsp.WriteHeader(e.StatusCode)
-		rsp.Write([]byte(e.Error.Error()))
-		return true
-	} else if e.ResponseKind == JSON {
-		rsp.Header().Set("Content-Type", "application/json; charset=utf-8")
-		rsp.WriteHeader(e.StatusCode)
-		j, jerr := json.Marshal(&struct {
-			StatusCode int    `json:"statusCode"`
-			Error      string `json:"error"`
-		}{
-			StatusCode: e.StatusCode,
-			Error:      e.Error.Error(),
-		})
-		if jerr != nil {
-			panic(jerr)
+	kind := e.ResponseKind
+	if kind == Undetermined {
+		kind = NegotiateResponseKind(req)
+		// JSON clients get problem details when there are any:
+		if kind == JSON && e.Problem != nil {
+			kind = Problem
 		}
-		rsp.Write(j)
-		return true
-	} else if e.ResponseKind == Empty {
-		rsp.WriteHeader(e.StatusCode)
-		return true
 	}
+	return e.render(rsp, req, kind)
+}
 
-	return false
+func (e *Error) render(rsp http.ResponseWriter, req *http.Request, kind ResponseKind) bool {
+	renderer := errorRenderer(kind)
+	if renderer == nil {
+		return false
+	}
+
+	renderer(rsp, req, e)
+	return true
+}
+
+// Returns the error message, or the status text if there is no error:
+func (e *Error) message() string {
+	if e.Error == nil {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Error.Error()
 }
 
 func (e *Error) AsJSON() *Error {
@@ -168,7 +181,7 @@ func Log(logfunc ErrorLogFunc, h ErrorHandler) ErrorHandler {
 func ReportErrors(h ErrorHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		werr := h.ServeHTTP(w, r)
-		werr.Respond(w)
+		werr.RespondTo(w, r)
 		return
 	})
 }